@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminConfig enables the admin API (the
+// hook management endpoints,) which requires
+// the Token as a bearer token. The Token can
+// reference ${env:NAME} and ${file:/path}
+// secrets.
+//
+// Hooks changed through the API are saved to
+// HooksFile, which replaces the config's hooks
+// when it exists at startup. Without one they
+// are only kept in memory.
+//
+// Hooks given through the API (including
+// inline dry run hooks) can only reference the
+// secrets listed in Secrets, written like
+// "${env:NAME}".
+type AdminConfig struct {
+	Token     string   `json:"token"`
+	HooksFile string   `json:"hooksFile,omitempty"`
+	Secrets   []string `json:"secrets,omitempty"`
+	token     string
+}
+
+// Admin only allows requests with the admin
+// token to a handler, else returning an
+// http.StatusUnauthorized status code (or an
+// http.StatusNotFound status code when the
+// admin API isn't enabled) as well as an error
+func Admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(r http.ResponseWriter, req *http.Request) {
+		if Config.Admin == nil {
+			r.Header().Add("Content-Type", "application/json")
+			r.WriteHeader(http.StatusNotFound)
+			r.Write([]byte(`{"message": "ERROR: the admin API is not enabled"}`))
+			return
+		}
+
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(Config.Admin.token)) != 1 {
+			r.Header().Add("Content-Type", "application/json")
+			r.Header().Add("WWW-Authenticate", `Bearer realm="sentiment-server"`)
+			r.WriteHeader(http.StatusUnauthorized)
+			r.Write([]byte(`{"message": "ERROR: a valid admin token must be given"}`))
+			return
+		}
+
+		h(r, req)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/cdipaolo/sentiment"
+)
+
+// epsilon keeps probabilities away from
+// 0 and 1 when taking log odds
+const epsilon = 1e-6
+
+// AnalysisResponse is the response given
+// for any analyzed document. It holds the
+// engine's analysis as well as anything
+// the server layered on top of it.
+type AnalysisResponse struct {
+	*sentiment.Analysis
+
+	// Overridden lists the words whose
+	// probabilities were changed by a
+	// Lexicon during scoring
+	Overridden []OverriddenWord `json:"overridden,omitempty"`
+
+	// Highlights holds the most positive and
+	// negative sentences, when requested
+	Highlights *Highlights `json:"highlights,omitempty"`
+
+	// Matches holds the separate analysis
+	// of each text matched by a hook's key
+	// when the hook scores them separately
+	Matches []*AnalysisResponse `json:"matches,omitempty"`
+}
+
+// Analyze runs sentiment analysis on the
+// given text, applying any lexicon
+// overrides to the word probabilities.
+// Sentences are split with the configured
+// segmenter.
+//
+// When a word is overridden the document
+// score (and the score of any sentence
+// holding that word) is recomputed as the
+// sum of the log odds of its words, which
+// is how the underlying Naive Bayes model
+// combines them in the first place.
+//
+// Analyses are served from the cache
+// when one is configured.
+func Analyze(text string, lang sentiment.Language, lex Lexicon) *AnalysisResponse {
+	if cache == nil {
+		return analyze(text, lang, lex)
+	}
+
+	return cache.CachedAnalysis(analysisKey(text, lang, lex), func() *AnalysisResponse {
+		return analyze(text, lang, lex)
+	})
+}
+
+// analyze runs the analysis behind Analyze
+func analyze(text string, lang sentiment.Language, lex Lexicon) *AnalysisResponse {
+	resp := &AnalysisResponse{
+		Analysis: model.SentimentAnalysis(text, lang),
+	}
+
+	if Config != nil && Config.Segmenter == ServerSegmenter {
+		resp.Sentences = nil
+
+		// the engine only gives sentences for
+		// documents with more than one
+		sentences := SegmentSentences(text, resp.Language)
+		if len(sentences) > 1 {
+			resp.Sentences = make([]sentiment.SentenceScore, len(sentences))
+			for i := range sentences {
+				resp.Sentences[i] = sentiment.SentenceScore{
+					Sentence: sentences[i],
+					Score:    model.SentimentAnalysis(sentences[i], resp.Language).Score,
+				}
+			}
+		}
+	}
+
+	if len(lex) == 0 {
+		return resp
+	}
+
+	resp.Overridden = applyLexicon(resp.Analysis.Words, lex)
+	if len(resp.Overridden) == 0 {
+		return resp
+	}
+
+	resp.Score = scoreWords(resp.Words)
+	for i := range resp.Sentences {
+		s := model.SentimentAnalysis(resp.Sentences[i].Sentence, resp.Language)
+		if len(applyLexicon(s.Words, lex)) != 0 {
+			resp.Sentences[i].Score = scoreWords(s.Words)
+		}
+	}
+
+	return resp
+}
+
+// applyLexicon overrides the given word scores
+// in place, returning the words it changed
+func applyLexicon(words []sentiment.Score, lex Lexicon) []OverriddenWord {
+	var overridden []OverriddenWord
+	for i := range words {
+		original := positiveProbability(words[i])
+		p, ok := lex.Lookup(words[i].Word, original)
+		if !ok {
+			continue
+		}
+
+		words[i].Score = 0
+		words[i].Probability = 1 - p
+		if p > 0.5 {
+			words[i].Score = 1
+			words[i].Probability = p
+		}
+
+		overridden = append(overridden, OverriddenWord{
+			Index:    i,
+			Word:     words[i].Word,
+			Original: original,
+			Positive: p,
+		})
+	}
+
+	return overridden
+}
+
+// positiveProbability returns the probability
+// that a word is positive (the engine gives the
+// probability of the predicted class instead)
+func positiveProbability(s sentiment.Score) float64 {
+	if s.Score == 1 {
+		return s.Probability
+	}
+	return 1 - s.Probability
+}
+
+// scoreWords sums the log odds of each word
+// being positive, returning 1 if the words
+// are, as a whole, more likely positive
+func scoreWords(words []sentiment.Score) uint8 {
+	var odds float64
+	for _, w := range words {
+		if strings.TrimSpace(w.Word) == "" {
+			continue
+		}
+
+		odds += logOdds(w)
+	}
+
+	if odds > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Confidence estimates the probability that the
+// given words are, as a whole, positive. It's
+// the logistic function of the mean log odds of
+// each word being positive, so it doesn't
+// saturate for long documents like the summed
+// log odds would.
+func Confidence(words []sentiment.Score) float64 {
+	n := 0
+	odds := 0.0
+	for _, w := range words {
+		if strings.TrimSpace(w.Word) == "" {
+			continue
+		}
+		odds += logOdds(w)
+		n++
+	}
+
+	if n == 0 {
+		return 0.5
+	}
+
+	return 1 / (1 + math.Exp(-odds/float64(n)))
+}
+
+// logOdds returns the log odds of a word
+// being positive
+func logOdds(w sentiment.Score) float64 {
+	p := math.Min(math.Max(positiveProbability(w), epsilon), 1-epsilon)
+	return math.Log(p / (1 - p))
+}
+
+// countWords returns the number of non-blank
+// words in an analysis
+func countWords(words []sentiment.Score) int {
+	n := 0
+	for _, w := range words {
+		if strings.TrimSpace(w.Word) != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// defaultHookConcurrency is the number of
+	// requests made to a hook at once within
+	// batches when the hook doesn't say
+	defaultHookConcurrency = 4
+
+	// defaultMaxBatchSize is the most ids a
+	// batch can give when the config
+	// doesn't say
+	defaultMaxBatchSize = 100
+)
+
+var (
+	// limiters holds the semaphores limiting
+	// concurrent batch requests to each hook,
+	// shared by every batch
+	limiters     = map[string]chan struct{}{}
+	limitersLock sync.Mutex
+)
+
+// BatchConfig holds the configuration for
+// batches of ids given to POST /task. MaxSize
+// is the most ids a batch can give (defaults
+// to 100.)
+type BatchConfig struct {
+	MaxSize int `json:"maxSize,omitempty"`
+}
+
+// maxSize returns the most ids a
+// batch can give
+func (c BatchConfig) maxSize() int {
+	if c.MaxSize < 1 {
+		return defaultMaxBatchSize
+	}
+	return c.MaxSize
+}
+
+// BatchResult is the result for a single
+// record id within a batch task. Exactly one
+// of Result (what POST /task would respond
+// with for the id) or Error is given.
+type BatchResult struct {
+	ID     string          `json:"recordingId"`
+	Result interface{}     `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// BatchResponse holds the results of a batch
+// task in the order the ids were given, along
+// with the number of ids which succeeded and
+// failed
+type BatchResponse struct {
+	Results   []BatchResult `json:"results"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+}
+
+// hookLimiter returns the semaphore for the
+// hook with the given id, (re)creating it if
+// the hook's concurrency has changed
+func hookLimiter(id string, hook Hook) chan struct{} {
+	concurrency := hook.Concurrency
+	if concurrency < 1 {
+		concurrency = defaultHookConcurrency
+	}
+
+	limitersLock.Lock()
+	defer limitersLock.Unlock()
+
+	limiter, ok := limiters[id]
+	if !ok || cap(limiter) != concurrency {
+		limiter = make(chan struct{}, concurrency)
+		limiters[id] = limiter
+	}
+
+	return limiter
+}
+
+// RunBatch runs the task for each of the ids in
+// a batch concurrently, calling emit with each
+// result as it finishes. The ids are run by a
+// pool of workers as large as the hook's
+// concurrency, which also limits the requests
+// made to the hook across every batch. The hook
+// is looked up once, so every id uses the same
+// definition even if the hook is changed during
+// the batch. emit is never called concurrently.
+// It returns the number of ids which succeeded.
+func RunBatch(j TaskJSON, emit func(int, BatchResult)) int {
+	id, hook, err := FindHook(j.HookID)
+	if err != nil {
+		// unknown hooks don't get a limiter,
+		// so they can't grow the limiters map
+		for i := range j.IDs {
+			emit(i, BatchResult{
+				ID:    j.IDs[i],
+				Error: errorJSON(err),
+			})
+		}
+		return 0
+	}
+	limiter := hookLimiter(id, hook)
+
+	var (
+		wg        sync.WaitGroup
+		lock      sync.Mutex
+		succeeded int
+	)
+	run := func(i int) {
+		result := BatchResult{
+			ID: j.IDs[i],
+		}
+
+		limiter <- struct{}{}
+		task := j
+		task.ID = j.IDs[i]
+		task.IDs = nil
+
+		r, err := runHookTask(id, &hook, task)
+		<-limiter
+
+		if err != nil {
+			result.Error = errorJSON(err)
+		} else {
+			result.Result = r.Response()
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if result.Error == nil {
+			succeeded++
+		}
+		emit(i, result)
+	}
+
+	workers := cap(limiter)
+	if workers > len(j.IDs) {
+		workers = len(j.IDs)
+	}
+
+	ids := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ids {
+				run(i)
+			}
+		}()
+	}
+	for i := range j.IDs {
+		ids <- i
+	}
+	close(ids)
+	wg.Wait()
+
+	return succeeded
+}
+
+// WantsNDJSON returns whether the request
+// asks for newline delimited JSON, going by
+// its Accept header
+func WantsNDJSON(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && (mediaType == "application/x-ndjson" || mediaType == "application/ndjson") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HandleBatch responds to a POST /task with a
+// list of record ids (refusing batches larger
+// than the configured maximum,) either with one
+// BatchResponse or, if the request accepts
+// application/x-ndjson, by streaming each
+// BatchResult on its own line as it finishes
+func HandleBatch(r http.ResponseWriter, req *http.Request, j TaskJSON) {
+	maxSize := defaultMaxBatchSize
+	if Config != nil {
+		maxSize = Config.Batches.maxSize()
+	}
+	if len(j.IDs) > maxSize {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: too many recordingIds given in the batch", "ids": %v, "maxSize": %v}`, len(j.IDs), maxSize)))
+		log.Printf("POST /task > ERROR: batch of %v ids is larger than %v\n", len(j.IDs), maxSize)
+		return
+	}
+
+	var succeeded int
+
+	if WantsNDJSON(req) {
+		r.Header().Set("Content-Type", "application/x-ndjson")
+		r.WriteHeader(http.StatusOK)
+		flusher, _ := r.(http.Flusher)
+
+		succeeded = RunBatch(j, func(i int, result BatchResult) {
+			line, err := json.Marshal(result)
+			if err != nil {
+				line = []byte(fmt.Sprintf(`{"recordingId": %q, "error": {"message": "ERROR: unable to marshal result into JSON", "error": %q}}`, result.ID, err.Error()))
+			}
+
+			r.Write(append(line, '\n'))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		})
+	} else {
+		batch := BatchResponse{
+			Results: make([]BatchResult, len(j.IDs)),
+		}
+		succeeded = RunBatch(j, func(i int, result BatchResult) {
+			batch.Results[i] = result
+		})
+		batch.Succeeded = succeeded
+		batch.Failed = len(j.IDs) - succeeded
+
+		resp, err := json.Marshal(batch)
+		if err != nil {
+			r.WriteHeader(http.StatusInternalServerError)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to marshal batch into JSON", "error": "%v"}`, err.Error())))
+			log.Printf("POST /task > ERROR: error marshalling batch into JSON\n\t%v\n", err)
+			return
+		}
+
+		r.WriteHeader(http.StatusOK)
+		r.Write(resp)
+	}
+
+	atomic.AddInt64(&hookCount, int64(succeeded))
+	atomic.AddInt64(&count, int64(succeeded))
+	log.Printf("POST /task [batch, ids = %v, succeeded = %v]\n", len(j.IDs), succeeded)
+}
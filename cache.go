@@ -0,0 +1,627 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cdipaolo/sentiment"
+)
+
+const (
+	// FetchCache holds the raw responses
+	// of hook requests
+	FetchCache = "fetch"
+
+	// AnalysisCache holds the analyses
+	// of documents
+	AnalysisCache = "analysis"
+)
+
+// cache holds cached hook fetches and
+// analyses. It is nil when caching is
+// not configured.
+var cache *Cache
+
+// CacheConfig configures the cache of hook
+// fetches and analyses. Size is the most
+// entries kept in memory (defaults to 1000)
+// and TTL is how long they stay fresh
+// (defaults to 5m.) When Dir is given entries
+// are also stored on disk there, so they
+// outlive restarts and memory evictions.
+//
+// Hook fetches are keyed by hook id and record
+// id, and respect the upstream's Cache-Control
+// header: no-store responses aren't cached,
+// no-cache responses are always revalidated,
+// and max-age replaces TTL. Stale responses
+// with an ETag or Last-Modified header are
+// revalidated with a conditional request.
+type CacheConfig struct {
+	Size int      `json:"size,omitempty"`
+	TTL  Duration `json:"ttl,omitempty"`
+	Dir  string   `json:"dir,omitempty"`
+}
+
+// Cache is an LRU cache with expiring
+// entries, optionally backed by a
+// directory on disk
+type Cache struct {
+	sync.Mutex
+
+	size    int
+	ttl     time.Duration
+	dir     string
+	order   *list.List
+	entries map[string]*list.Element
+
+	stats map[string]*CacheStats
+}
+
+// CacheEntry is a cached value along with
+// what's needed to revalidate it
+type CacheEntry struct {
+	Key          string    `json:"key"`
+	Value        []byte    `json:"value"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Link         string    `json:"link,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// CacheStats counts the lookups
+// made against a cache
+type CacheStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Revalidated int64 `json:"revalidated,omitempty"`
+}
+
+// CachePurgeJSON is the JSON expected by
+// POST /cache/purge. An empty HookID purges
+// everything, otherwise only that hook's
+// fetches are purged.
+type CachePurgeJSON struct {
+	HookID string `json:"hookId,omitempty"`
+}
+
+// modelVersion identifies the sentiment
+// model for analysis cache keys so analyses
+// made by an older model aren't reused
+var modelVersion = func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/cdipaolo/sentiment" {
+			return dep.Version + dep.Sum
+		}
+	}
+
+	return "unknown"
+}()
+
+// NewCache makes a cache from the config,
+// creating its directory when given
+func NewCache(c CacheConfig) (*Cache, error) {
+	if c.Size < 1 {
+		c.Size = 1000
+	}
+	if c.TTL <= 0 {
+		c.TTL = Duration(5 * time.Minute)
+	}
+
+	if c.Dir != "" {
+		err := os.MkdirAll(c.Dir, 0700)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR: error creating cache directory %v: %v", c.Dir, err)
+		}
+	}
+
+	return &Cache{
+		size:    c.Size,
+		ttl:     time.Duration(c.TTL),
+		dir:     c.Dir,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+		stats: map[string]*CacheStats{
+			FetchCache:    {},
+			AnalysisCache: {},
+		},
+	}, nil
+}
+
+// Get returns the entry with the given key,
+// if there is one. Entries past their expiry
+// are still returned when they can be
+// revalidated.
+func (c *Cache) Get(key string) (*CacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if ok {
+		entry := e.Value.(*CacheEntry)
+		if entry.fresh() || entry.revalidatable() {
+			c.order.MoveToFront(e)
+			return entry, true
+		}
+
+		c.remove(e)
+		return nil, false
+	}
+
+	entry, ok := c.load(key)
+	if !ok {
+		return nil, false
+	}
+	c.put(entry)
+
+	return entry, true
+}
+
+// Set stores an entry, evicting the least
+// recently used entries from memory when
+// the cache is full
+func (c *Cache) Set(entry *CacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.put(entry)
+	c.store(entry)
+}
+
+// Purge removes every entry whose key
+// starts with the given prefix, returning
+// how many were removed
+func (c *Cache) Purge(prefix string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	purged := map[string]bool{}
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+			purged[key] = true
+		}
+	}
+
+	if c.dir == "" {
+		return len(purged)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	for _, file := range files {
+		entry, ok := readCacheFile(file)
+		if ok && !strings.HasPrefix(entry.Key, prefix) {
+			continue
+		}
+
+		os.Remove(file)
+		if ok {
+			purged[entry.Key] = true
+		}
+	}
+
+	return len(purged)
+}
+
+// Stats returns a snapshot of the hits
+// and misses of each part of the cache
+func (c *Cache) Stats() map[string]CacheStats {
+	stats := map[string]CacheStats{}
+	for name, s := range c.stats {
+		stats[name] = CacheStats{
+			Hits:        atomic.LoadInt64(&s.Hits),
+			Misses:      atomic.LoadInt64(&s.Misses),
+			Revalidated: atomic.LoadInt64(&s.Revalidated),
+		}
+	}
+
+	return stats
+}
+
+// Len returns the number of
+// entries held in memory
+func (c *Cache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.order.Len()
+}
+
+// hit, miss, and revalidated
+// count lookups in a part of
+// the cache
+func (c *Cache) hit(name string) {
+	atomic.AddInt64(&c.stats[name].Hits, 1)
+}
+
+func (c *Cache) miss(name string) {
+	atomic.AddInt64(&c.stats[name].Misses, 1)
+}
+
+func (c *Cache) revalidated(name string) {
+	atomic.AddInt64(&c.stats[name].Revalidated, 1)
+}
+
+// put adds an entry to memory. The
+// caller must hold the lock.
+func (c *Cache) put(entry *CacheEntry) {
+	if e, ok := c.entries[entry.Key]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[entry.Key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*CacheEntry).Key)
+	}
+}
+
+// remove drops an entry from memory and
+// disk. The caller must hold the lock.
+func (c *Cache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*CacheEntry)
+	delete(c.entries, entry.Key)
+
+	if c.dir != "" {
+		os.Remove(c.path(entry.Key))
+	}
+}
+
+// path returns the file an entry
+// is stored in on disk
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, hashHex([]byte(key))+".json")
+}
+
+// store writes an entry to disk, if
+// the cache has a directory. Entries
+// are written to a temporary file
+// first so readers never see a
+// partial entry.
+func (c *Cache) store(entry *CacheEntry) {
+	if c.dir == "" {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, ".entry-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	os.Rename(tmp.Name(), c.path(entry.Key))
+}
+
+// load reads an entry from disk, if
+// the cache has a directory and the
+// entry is still usable
+func (c *Cache) load(key string) (*CacheEntry, bool) {
+	if c.dir == "" {
+		return nil, false
+	}
+
+	entry, ok := readCacheFile(c.path(key))
+	if !ok || entry.Key != key {
+		return nil, false
+	}
+	if !entry.fresh() && !entry.revalidatable() {
+		os.Remove(c.path(key))
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// readCacheFile reads an entry
+// stored on disk
+func readCacheFile(path string) (*CacheEntry, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &CacheEntry{}
+	err = json.Unmarshal(data, entry)
+	if err != nil {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// fresh returns whether the entry can
+// be used without revalidating it
+func (e *CacheEntry) fresh() bool {
+	return time.Now().Before(e.Expires)
+}
+
+// response returns the entry as the
+// upstream response it was cached from
+func (e *CacheEntry) response() *UpstreamResponse {
+	header := http.Header{}
+	for name, value := range map[string]string{"ETag": e.ETag, "Last-Modified": e.LastModified, "Link": e.Link} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	return &UpstreamResponse{
+		Status: http.StatusOK,
+		Header: header,
+		Body:   e.Value,
+	}
+}
+
+// revalidatable returns whether the entry
+// can be revalidated with the upstream
+// through a conditional request
+func (e *CacheEntry) revalidatable() bool {
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// fetchKey returns the cache key for
+// the hook fetch of a task, or "" when the
+// task skips the cache. The id is escaped
+// so it can't pass for params.
+func fetchKey(hookID string, j TaskJSON) string {
+	if j.noCache {
+		return ""
+	}
+
+	key := FetchCache + "/" + hookID + "/" + url.PathEscape(j.ID)
+	if len(j.Params) == 0 {
+		return key
+	}
+
+	params := url.Values{}
+	for name, value := range j.Params {
+		params.Set(name, value)
+	}
+
+	return key + "?" + params.Encode()
+}
+
+// analysisKey returns the cache key for
+// the analysis of a document. It covers
+// everything which changes the result:
+// the text, language, model, segmenter
+// and lexicon.
+func analysisKey(text string, lang sentiment.Language, lex Lexicon) string {
+	segmenter := ""
+	if Config != nil {
+		segmenter = Config.Segmenter
+	}
+
+	lexicon := []byte{}
+	if len(lex) != 0 {
+		lexicon, _ = json.Marshal(lex)
+	}
+
+	return fmt.Sprintf("%v/%v/%v/%v/%v/%v", AnalysisCache, hashHex([]byte(text)), lang, hashHex([]byte(modelVersion)), segmenter, hashHex(lexicon))
+}
+
+// hashHex returns the hex SHA-256 of data
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// CachedFetch performs a hook request through
+// the cache. Fresh responses are served from
+// the cache, stale ones are revalidated with
+// a conditional request when possible, and
+// new responses are cached as the upstream's
+// Cache-Control header allows. Responses served
+// from the cache only keep the validator and
+// Link headers.
+func (c *Cache) CachedFetch(key string, req *http.Request, fetch func(*http.Request) (*UpstreamResponse, error)) (*UpstreamResponse, error) {
+	entry, ok := c.Get(key)
+	if ok && entry.fresh() {
+		c.hit(FetchCache)
+		return entry.response(), nil
+	}
+
+	if ok {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := fetch(req)
+	if err != nil {
+		c.miss(FetchCache)
+		return nil, err
+	}
+
+	if resp.NotModified() && ok {
+		c.revalidated(FetchCache)
+
+		refreshed := *entry
+		refreshed.Expires, _ = c.expiry(resp.Header)
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			refreshed.ETag = etag
+		}
+		c.Set(&refreshed)
+
+		return refreshed.response(), nil
+	}
+
+	c.miss(FetchCache)
+
+	expires, cacheable := c.expiry(resp.Header)
+	if cacheable {
+		c.Set(&CacheEntry{
+			Key:          key,
+			Value:        resp.Body,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Link:         resp.Header.Get("Link"),
+			Expires:      expires,
+		})
+	}
+
+	return resp, nil
+}
+
+// expiry returns when a response expires
+// from the cache according to its
+// Cache-Control header, and whether it
+// may be cached at all
+func (c *Cache) expiry(header http.Header) (time.Time, bool) {
+	now := time.Now()
+	ttl := c.ttl
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		switch {
+		case directive == "no-store":
+			return now, false
+		case directive == "no-cache":
+			return now, true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && seconds >= 0 {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return now.Add(ttl), true
+}
+
+// CachedAnalysis returns the analysis of a
+// document from the cache, running analyze
+// and caching the result on a miss. Every
+// call gets its own copy of the analysis,
+// so callers are free to change it.
+func (c *Cache) CachedAnalysis(key string, analyze func() *AnalysisResponse) *AnalysisResponse {
+	entry, ok := c.Get(key)
+	if ok && entry.fresh() {
+		cached := &AnalysisResponse{}
+		err := json.Unmarshal(entry.Value, cached)
+		if err == nil {
+			c.hit(AnalysisCache)
+			return cached
+		}
+	}
+
+	c.miss(AnalysisCache)
+	resp := analyze()
+
+	data, err := json.Marshal(resp)
+	if err == nil {
+		c.Set(&CacheEntry{
+			Key:     key,
+			Value:   data,
+			Expires: time.Now().Add(c.ttl),
+		})
+	}
+
+	return resp
+}
+
+// HandleCacheStats gives the hit and miss
+// counts of the cache
+func HandleCacheStats(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	if cache == nil {
+		r.WriteHeader(http.StatusNotFound)
+		r.Write([]byte(`{"message": "ERROR: no cache is configured"}`))
+		return
+	}
+
+	stats, err := json.Marshal(struct {
+		Entries int                   `json:"entries"`
+		Stats   map[string]CacheStats `json:"stats"`
+	}{
+		Entries: cache.Len(),
+		Stats:   cache.Stats(),
+	})
+	if err != nil {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error marshalling cache stats", "error": "%v"}`, err)))
+		return
+	}
+
+	r.WriteHeader(http.StatusOK)
+	r.Write(stats)
+}
+
+// HandleCachePurge purges the cache, or
+// just one hook's fetches when a hookId
+// is given
+func HandleCachePurge(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	if cache == nil {
+		r.WriteHeader(http.StatusNotFound)
+		r.Write([]byte(`{"message": "ERROR: no cache is configured"}`))
+		return
+	}
+
+	j := CachePurgeJSON{}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error reading request body", "error": "%v"}`, err.Error())))
+		log.Printf("POST /cache/purge > ERROR: couldn't read request body\n\t%v\n", err)
+		return
+	}
+	if len(strings.TrimSpace(string(body))) != 0 {
+		err = json.Unmarshal(body, &j)
+		if err != nil {
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error unmarshalling given JSON into expected format", "error": "%v"}`, err.Error())))
+			log.Printf("POST /cache/purge > ERROR: error unmarshalling given JSON\n\t%v\n", err)
+			return
+		}
+	}
+
+	prefix := ""
+	if j.HookID != "" {
+		prefix = FetchCache + "/" + j.HookID + "/"
+	}
+
+	purged := cache.Purge(prefix)
+	log.Printf("POST /cache/purge > purged %v entries\n", purged)
+
+	r.WriteHeader(http.StatusOK)
+	r.Write([]byte(fmt.Sprintf(`{"purged": %v}`, purged)))
+}
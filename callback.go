@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DeliveryPending means the callback
+	// hasn't succeeded yet but will be
+	// retried
+	DeliveryPending = "pending"
+
+	// DeliveryDelivered means the callback
+	// responded with a 2xx status
+	DeliveryDelivered = "delivered"
+
+	// DeliveryFailed means every attempt
+	// at the callback failed
+	DeliveryFailed = "failed"
+
+	// SignatureHeader holds the hex HMAC-SHA256
+	// of the callback body, prefixed with
+	// "sha256=", when a secret is configured
+	SignatureHeader = "X-Sentiment-Signature"
+)
+
+// Callback is a URL which is POSTed the
+// result of a job when it completes, along
+// with any headers given (for auth, etc.)
+type Callback struct {
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+// CallbacksConfig holds the configuration
+// for callback delivery. Secret is the key
+// callback bodies are signed with (bodies
+// aren't signed if it's blank.) Failed
+// deliveries are retried up to MaxAttempts
+// times in total (defaults to 5,) waiting
+// Backoff after the first failure and doubling
+// after each following one, up to MaxBackoff
+// (defaults to 1s and 1m.) Timeout caps each
+// attempt (defaults to 10s.)
+//
+// Outbound restricts where callbacks can be
+// sent, like the outbound policy of hooks. It's
+// separate from the hooks' policy since anyone
+// making a task can give a callback URL, so by
+// default callbacks can't reach private,
+// loopback or link-local IPs.
+type CallbacksConfig struct {
+	Secret      string         `json:"secret,omitempty"`
+	MaxAttempts int            `json:"maxAttempts,omitempty"`
+	Backoff     Duration       `json:"backoff,omitempty"`
+	MaxBackoff  Duration       `json:"maxBackoff,omitempty"`
+	Timeout     Duration       `json:"timeout,omitempty"`
+	Outbound    OutboundPolicy `json:"outbound,omitempty"`
+
+	policy *OutboundPolicy
+	client *http.Client
+}
+
+// Delivery records the attempts made to
+// deliver a job's result to its callback
+type Delivery struct {
+	URL      string            `json:"url"`
+	Status   string            `json:"status"`
+	Attempts []DeliveryAttempt `json:"attempts"`
+}
+
+// DeliveryAttempt is a single try at
+// POSTing to a callback. StatusCode is 0
+// if the request couldn't be completed.
+type DeliveryAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Validate makes sure the callback has an HTTP
+// or HTTPS URL allowed by the configured
+// callback outbound policy. Hosts are checked
+// again once they're resolved, when the
+// callback is delivered.
+func (c *Callback) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid callback url '%v': %v", c.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback url '%v' must be an absolute http or https url", c.URL)
+	}
+
+	policy := callbackPolicy(nil)
+	if Config != nil {
+		policy = callbackPolicy(&Config.Callbacks.Outbound)
+	}
+
+	err = policy.AllowsURL(u)
+	if err != nil {
+		return fmt.Errorf("callback url '%v' is not allowed: %v", c.URL, err)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !policy.AllowsIP(ip) {
+		return fmt.Errorf("callback url '%v' is not allowed: connecting to %v is not allowed", c.URL, ip)
+	}
+
+	return nil
+}
+
+// callbackPolicy returns the outbound policy
+// callbacks are checked against. Invalid
+// policies (which are refused when the config
+// is loaded) allow only the default networks.
+func callbackPolicy(outbound *OutboundPolicy) *OutboundPolicy {
+	policy, err := mergeOutbound(outbound)
+	if err != nil {
+		return &OutboundPolicy{}
+	}
+	return policy
+}
+
+// withDefaults fills in the default
+// callback delivery settings
+func (c CallbacksConfig) withDefaults() CallbacksConfig {
+	if c.MaxAttempts < 1 {
+		c.MaxAttempts = 5
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = Duration(time.Second)
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = Duration(time.Minute)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = Duration(10 * time.Second)
+	}
+	c.policy = callbackPolicy(&c.Outbound)
+	c.client = c.newClient()
+
+	return c
+}
+
+// newClient builds the client callbacks are
+// delivered with, which checks connections and
+// redirects against the callback policy
+func (c CallbacksConfig) newClient() *http.Client {
+	policy := c.policy
+	if policy == nil {
+		policy = callbackPolicy(&c.Outbound)
+	}
+
+	return ClientConfig{
+		ConnectTimeout: c.Timeout,
+		Timeout:        c.Timeout,
+	}.withDefaults().NewClient(nil, policy)
+}
+
+// Sign returns the signature header value
+// for a callback body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver POSTs a finished job's body to its
+// callback, retrying with exponential backoff
+// and recording each attempt on the job
+func (q *JobQueue) deliver(job *Job, body []byte) {
+	q.Lock()
+	callback := *job.callback
+	status := job.Status
+	q.Unlock()
+
+	headers := map[string]string{
+		"X-Sentiment-Job":    job.ID,
+		"X-Sentiment-Status": status,
+	}
+
+	retryDelivery(q.callbacks, func(client *http.Client) (int, error) {
+		return postCallback(client, callback, body, q.callbacks.Secret, headers)
+	}, func(attempt int, record DeliveryAttempt, delivery string) {
+		q.Lock()
+		job.Delivery.Attempts = append(job.Delivery.Attempts, record)
+		job.Delivery.Status = delivery
+		q.Unlock()
+
+		if record.Error == "" {
+			log.Printf("JOB %v [callback delivered, attempt = %v]\n", job.ID, attempt)
+		} else {
+			log.Printf("JOB %v > ERROR: callback attempt %v failed\n\t%v\n", job.ID, attempt, record.Error)
+		}
+	})
+}
+
+// retryDelivery calls post until it succeeds or
+// every attempt has failed, backing off between
+// attempts. Each attempt is passed to record
+// along with the delivery status after it. The
+// client given to post is shared by every
+// delivery with the config, and checks
+// connections and redirects against the
+// callback policy.
+func retryDelivery(c CallbacksConfig, post func(*http.Client) (int, error), record func(int, DeliveryAttempt, string)) {
+	client := c.client
+	if client == nil {
+		client = c.newClient()
+	}
+
+	backoff := time.Duration(c.Backoff)
+	for attempt := 1; attempt <= c.MaxAttempts; attempt++ {
+		r := DeliveryAttempt{
+			Time: time.Now().UTC(),
+		}
+
+		code, err := post(client)
+		r.StatusCode = code
+		if err != nil {
+			r.Error = err.Error()
+		}
+
+		status := DeliveryPending
+		if err == nil {
+			status = DeliveryDelivered
+		} else if attempt == c.MaxAttempts {
+			status = DeliveryFailed
+		}
+		record(attempt, r, status)
+
+		if err == nil || attempt == c.MaxAttempts {
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > time.Duration(c.MaxBackoff) {
+			backoff = time.Duration(c.MaxBackoff)
+		}
+	}
+}
+
+// postCallback makes a single POST to a callback
+// with the given extra headers, returning the
+// response status and an error if the request
+// failed or wasn't a 2xx
+func postCallback(client *http.Client, callback Callback, body []byte, secret string, headers map[string]string) (int, error) {
+	req, err := http.NewRequest("POST", callback.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	for key, values := range callback.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// read a little so the connection can
+	// be reused, without reading whatever
+	// the receiver sends
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxExcerptSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("callback responded with status %v", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cdipaolo/sentiment"
+)
+
+// CollectionFields turns a hook into a
+// collection hook, where each element found
+// by the hook's Key (or each element of the
+// top level array, without a Key) is its own
+// document. ID and Text are JSONPath
+// expressions relative to each element,
+// defaulting to the "id" and "text" fields.
+type CollectionFields struct {
+	ID   string `json:"id,omitempty"`
+	Text string `json:"text,omitempty"`
+
+	id, text *JSONPath
+}
+
+// CollectionItem is a single document within
+// a collection. ID is whatever the element's
+// id field held (nil if it had none.) Feed
+// entries also give their Title and Time
+// (when they were published or updated.)
+type CollectionItem struct {
+	ID       interface{}       `json:"id"`
+	Title    string            `json:"title,omitempty"`
+	Time     *time.Time        `json:"time,omitempty"`
+	Text     string            `json:"text"`
+	Analysis *AnalysisResponse `json:"analysis,omitempty"`
+}
+
+// CollectionAggregate summarizes the analyses
+// of every item within a collection
+type CollectionAggregate struct {
+	Count          int     `json:"count"`
+	Positive       int     `json:"positive"`
+	Negative       int     `json:"negative"`
+	MeanConfidence float64 `json:"meanConfidence"`
+}
+
+// CollectionResponse is returned for collection
+// hooks. It holds the analysis of each item (in
+// the order given by the hook,) the aggregate of
+// those, and the analysis of all the items'
+// text together within "metadata."
+type CollectionResponse struct {
+	Metadata  *AnalysisResponse   `json:"metadata,omitempty"`
+	Items     []CollectionItem    `json:"items"`
+	Aggregate CollectionAggregate `json:"aggregate"`
+}
+
+// compile compiles the collection field
+// paths with the hook's compiler, filling
+// in the defaults
+func (c *CollectionFields) compile(compile func(string) (*JSONPath, error)) error {
+	if c.ID == "" {
+		c.ID = "id"
+	}
+	if c.Text == "" {
+		c.Text = "text"
+	}
+
+	var err error
+	c.id, err = compile(c.ID)
+	if err != nil {
+		return fmt.Errorf("invalid id: %v", err)
+	}
+	c.text, err = compile(c.Text)
+	if err != nil {
+		return fmt.Errorf("invalid text: %v", err)
+	}
+
+	return nil
+}
+
+// item reads a single collection item
+func (c *CollectionFields) item(v interface{}) (CollectionItem, error) {
+	var item CollectionItem
+
+	if m := c.id.Find(v); len(m) != 0 {
+		item.ID = m[0]
+	}
+
+	m := c.text.Find(v)
+	if len(m) == 0 {
+		return item, fmt.Errorf("no text found at '%v'", c.Text)
+	}
+
+	text, ok := textValue(m[0])
+	if !ok {
+		return item, fmt.Errorf("text '%v' is not a string", c.Text)
+	}
+	item.Text = text
+
+	return item, nil
+}
+
+// extractCollection reads the items out of
+// a decoded hook response body
+func (h *Hook) extractCollection(id string, body interface{}) ([]CollectionItem, error) {
+	elements := []interface{}{body}
+	if h.Key != "" {
+		elements = h.keyPath.Find(body)
+	}
+	if len(elements) == 1 {
+		if arr, ok := elements[0].([]interface{}); ok {
+			elements = arr
+		} else if h.Key == "" {
+			return nil, fmt.Errorf(`{"message": "ERROR: expected a top level array from collection HOOK request", "hook": "%v"}`, id)
+		}
+	}
+
+	items := []CollectionItem{}
+	for i := range elements {
+		item, err := h.Collection.item(elements[i])
+		if err != nil {
+			return nil, fmt.Errorf(`{"message": "ERROR: could not read collection item from HOOK request", "hook": "%v", "key": "%v", "item": %v, "error": "%v"}`, id, h.Key, i, err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// AnalyzeCollection analyzes each item within
+// a collection in place, returning the
+// aggregate of the analyses
+func AnalyzeCollection(items []CollectionItem, lang sentiment.Language, lex Lexicon) CollectionAggregate {
+	aggregate := CollectionAggregate{
+		Count: len(items),
+	}
+
+	total := 0.0
+	for i := range items {
+		items[i].Analysis = Analyze(items[i].Text, lang, lex)
+
+		if items[i].Analysis.Score == 1 {
+			aggregate.Positive++
+		} else {
+			aggregate.Negative++
+		}
+		total += Confidence(items[i].Analysis.Words)
+	}
+
+	if len(items) != 0 {
+		aggregate.MeanConfidence = total / float64(len(items))
+	}
+
+	return aggregate
+}
+
+// collectionText joins the text of every
+// item in a collection
+func collectionText(items []CollectionItem) string {
+	texts := make([]string, len(items))
+	for i := range items {
+		texts[i] = items[i].Text
+	}
+
+	return strings.Join(texts, " ")
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cdipaolo/sentiment"
+)
+
+const (
+	// defaultCompareLimit is the number of words
+	// and sentences returned by POST /compare
+	// when no limit is given
+	defaultCompareLimit = 10
+)
+
+// CompareResponse holds the analysis of both
+// documents in a comparison along with the
+// difference between them.
+//
+// Confidence is the estimated probability that
+// a document is positive, and Delta is B's
+// confidence minus A's. Words and Sentences
+// hold the items whose contributions to the
+// document sentiment changed most from A to B,
+// largest change first.
+type CompareResponse struct {
+	A *AnalysisResponse `json:"a"`
+	B *AnalysisResponse `json:"b"`
+
+	ConfidenceA float64 `json:"confidenceA"`
+	ConfidenceB float64 `json:"confidenceB"`
+	Delta       float64 `json:"delta"`
+
+	Words     []ContributionDelta `json:"words"`
+	Sentences []ContributionDelta `json:"sentences"`
+}
+
+// ContributionDelta holds the contribution of
+// a word or sentence to each document of a
+// comparison. Contributions are the share of
+// the document's mean log odds of being
+// positive that come from the item (0 if the
+// item isn't in the document.)
+type ContributionDelta struct {
+	Text  string  `json:"text"`
+	A     float64 `json:"a"`
+	B     float64 `json:"b"`
+	Delta float64 `json:"delta"`
+}
+
+// HandleCompare takes a POST with two documents
+// (either text or hooked records), analyzes both,
+// and returns the difference in sentiment between
+// them as well as the words and sentences which
+// account for most of that difference.
+func HandleCompare(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	if req.ContentLength < 1 {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "no documents passed. Cannot run comparison"}`)))
+		log.Printf("POST /compare > ERROR: no documents passed\n")
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil && err != io.EOF {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error reading request body", "error": "%v"}`, err.Error())))
+		log.Printf("POST /compare > ERROR: couldn't read request body\n\t%v\n", err)
+		return
+	}
+
+	j := CompareJSON{}
+	err = json.Unmarshal(data, &j)
+	if err != nil {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error unmarshalling given JSON into expected format", "error": "%v"}`, err.Error())))
+		log.Printf("POST /compare > ERROR: error unmarshalling given JSON\n\t%v\n", err)
+		return
+	}
+
+	err = j.Lexicon.Validate()
+	if err != nil {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: invalid lexicon given", "error": "%v"}`, err.Error())))
+		log.Printf("POST /compare > ERROR: invalid lexicon given\n\t%v\n", err)
+		return
+	}
+
+	textA, langA, lexA, err := j.A.Resolve(j.Lexicon)
+	if err != nil {
+		r.WriteHeader(ErrorStatus(err))
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to get text for document 'a'", "error": %v}`, err)))
+		log.Printf("POST /compare > ERROR: error getting document 'a'\n\t%v\n", err)
+		return
+	}
+
+	textB, langB, lexB, err := j.B.Resolve(j.Lexicon)
+	if err != nil {
+		r.WriteHeader(ErrorStatus(err))
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to get text for document 'b'", "error": %v}`, err)))
+		log.Printf("POST /compare > ERROR: error getting document 'b'\n\t%v\n", err)
+		return
+	}
+
+	limit := j.Limit
+	if limit < 1 {
+		limit = defaultCompareLimit
+	}
+
+	resp, err := json.Marshal(Compare(textA, langA, lexA, textB, langB, lexB, limit))
+	if err != nil {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to marshal comparison into JSON", "error": "%v"}`, err.Error())))
+		log.Printf("POST /compare > ERROR: unable to marshal comparison into JSON\n\t%v\n", err)
+		return
+	}
+
+	r.WriteHeader(http.StatusOK)
+	r.Write(resp)
+
+	atomic.AddInt64(&count, 2)
+	log.Printf("POST /compare [len(a) = %v, len(b) = %v]\n", len(textA), len(textB))
+}
+
+// Resolve returns the text, language, and
+// lexicon to analyze a compared document with,
+// fetching the text from the document's hook
+// if it wasn't given inline. The hook is looked
+// up once, so the text and lexicon come from
+// the same definition even if the hook is
+// changed meanwhile.
+func (d CompareDocument) Resolve(lex Lexicon) (string, sentiment.Language, Lexicon, error) {
+	if d.Text != "" {
+		return d.Text, d.Language, MergeLexicons(Config.Lexicon, lex), nil
+	}
+
+	id, hook, err := FindHook(d.HookID)
+	if err != nil {
+		return "", sentiment.NoLanguage, nil, err
+	}
+
+	r, err := hook.Fetch(id, TaskJSON{
+		ID:     d.ID,
+		HookID: d.HookID,
+	})
+	if err != nil {
+		return "", sentiment.NoLanguage, nil, err
+	}
+
+	return r.Text, r.Language, MergeLexicons(Config.Lexicon, hook.Lexicon, lex), nil
+}
+
+// Compare analyzes two documents and returns the
+// difference in their sentiment, limiting the
+// returned word and sentence deltas to limit
+// items each
+func Compare(textA string, langA sentiment.Language, lexA Lexicon, textB string, langB sentiment.Language, lexB Lexicon, limit int) *CompareResponse {
+	a := Analyze(textA, langA, lexA)
+	b := Analyze(textB, langB, lexB)
+
+	resp := &CompareResponse{
+		A:           a,
+		B:           b,
+		ConfidenceA: Confidence(a.Words),
+		ConfidenceB: Confidence(b.Words),
+	}
+	resp.Delta = resp.ConfidenceB - resp.ConfidenceA
+
+	resp.Words = diffContributions(
+		wordContributions(a.Words),
+		wordContributions(b.Words),
+		limit,
+	)
+	resp.Sentences = diffContributions(
+		sentenceContributions(a, lexA),
+		sentenceContributions(b, lexB),
+		limit,
+	)
+
+	return resp
+}
+
+// wordContributions maps each normalized word
+// to its share of the document's mean log odds
+func wordContributions(words []sentiment.Score) map[string]float64 {
+	n := countWords(words)
+	contributions := map[string]float64{}
+	for _, w := range words {
+		word := NormalizeWord(w.Word)
+		if word == "" {
+			continue
+		}
+		contributions[word] += logOdds(w) / float64(n)
+	}
+
+	return contributions
+}
+
+// sentenceContributions maps each sentence (with
+// whitespace trimmed) to its share of the
+// document's mean log odds
+func sentenceContributions(a *AnalysisResponse, lex Lexicon) map[string]float64 {
+	n := countWords(a.Words)
+	contributions := map[string]float64{}
+	if n == 0 {
+		return contributions
+	}
+
+	if len(a.Sentences) == 0 {
+		odds := 0.0
+		text := []string{}
+		for _, w := range a.Words {
+			if strings.TrimSpace(w.Word) == "" {
+				continue
+			}
+			odds += logOdds(w)
+			text = append(text, w.Word)
+		}
+		contributions[strings.Join(text, " ")] = odds / float64(n)
+		return contributions
+	}
+
+	for _, s := range a.Sentences {
+		sentence := strings.TrimSpace(s.Sentence)
+		if sentence == "" {
+			continue
+		}
+
+		odds := 0.0
+		for _, w := range Analyze(sentence, a.Language, lex).Words {
+			if strings.TrimSpace(w.Word) == "" {
+				continue
+			}
+			odds += logOdds(w)
+		}
+		contributions[sentence] += odds / float64(n)
+	}
+
+	return contributions
+}
+
+// diffContributions returns the items whose
+// contributions differ most between a and b,
+// sorted by the magnitude of that difference
+func diffContributions(a, b map[string]float64, limit int) []ContributionDelta {
+	deltas := []ContributionDelta{}
+	for text, contribution := range a {
+		deltas = append(deltas, ContributionDelta{
+			Text:  text,
+			A:     contribution,
+			B:     b[text],
+			Delta: b[text] - contribution,
+		})
+	}
+	for text, contribution := range b {
+		if _, ok := a[text]; ok {
+			continue
+		}
+		deltas = append(deltas, ContributionDelta{
+			Text:  text,
+			B:     contribution,
+			Delta: contribution,
+		})
+	}
+
+	sort.SliceStable(deltas, func(i, j int) bool {
+		di, dj := math.Abs(deltas[i].Delta), math.Abs(deltas[j].Delta)
+		if di != dj {
+			return di > dj
+		}
+		return deltas[i].Text < deltas[j].Text
+	})
+
+	if len(deltas) > limit {
+		deltas = deltas[:limit]
+	}
+
+	return deltas
+}
@@ -9,6 +9,9 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/cdipaolo/sentiment"
 )
 
 const (
@@ -40,12 +43,90 @@ var (
 // when you only pass one hook in the config.
 // When padding multiple, it defaults to
 // a random hook.
+//
+// Jobs configures the workers behind
+// asynchronous POST /task requests, and
+// Callbacks configures how their results
+// are delivered to callback URLs (and where
+// those URLs can point.) Batches limits the
+// ids given in one POST /task.
+//
+// Cache, when given, caches hook fetches and
+// analyses so repeated tasks don't hit the
+// upstream or the model again.
+//
+// Outbound is the outbound policy for every
+// hook's requests. By default hooks can't
+// reach private, loopback or link-local IPs.
+//
+// Admin enables the admin API, which manages
+// hooks while the server runs.
+//
+// Schedules runs tasks on an interval or cron
+// expression, keeping a history of their scores
+// and alerting when the sentiment moves. Their
+// state is given by the admin API.
+//
+// Lexicon holds word-level sentiment overrides
+// applied to every analysis. Hooks and requests
+// can give their own lexicons on top of it.
+//
+// Segmenter chooses how documents are split
+// into sentences: "engine" (the default) uses
+// the sentiment engine's splitter, while
+// "server" uses SegmentSentences. Abbreviations
+// adds to the abbreviations the server
+// segmenter knows about, by language.
 type Configuration struct {
 	Port       int16 `json:"port,omitempty"`
 	portString string
 
 	Hooks       map[string]Hook `json:"hooks,omitempty"`
 	DefaultHook string          `json:"defaultHook,omitempty"`
+
+	Lexicon Lexicon `json:"lexicon,omitempty"`
+
+	Segmenter     string                          `json:"segmenter,omitempty"`
+	Abbreviations map[sentiment.Language][]string `json:"abbreviations,omitempty"`
+
+	Jobs      JobsConfig      `json:"jobs,omitempty"`
+	Callbacks CallbacksConfig `json:"callbacks,omitempty"`
+	Batches   BatchConfig     `json:"batches,omitempty"`
+
+	Cache *CacheConfig `json:"cache,omitempty"`
+
+	Outbound OutboundPolicy `json:"outbound,omitempty"`
+
+	Admin *AdminConfig `json:"admin,omitempty"`
+
+	Schedules map[string]ScheduleConfig `json:"schedules,omitempty"`
+}
+
+// Duration is a time.Duration which is given
+// in JSON as a string parsable by
+// time.ParseDuration (eg. "1h30m", "500ms")
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("durations must be given as strings like \"1m30s\": %v", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON formats a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
 }
 
 // init grabs the config from the expected
@@ -89,12 +170,6 @@ func ParseConfigFromFile() error {
 
 	Config.portString = fmt.Sprintf(":%v", Config.Port)
 
-	if Config.DefaultHook == "" {
-		for id := range Config.Hooks {
-			Config.DefaultHook = id
-		}
-	}
-
 	return nil
 }
 
@@ -126,12 +201,6 @@ func ParseConfigFromURL() error {
 
 	Config.portString = fmt.Sprintf(":%v", Config.Port)
 
-	if Config.DefaultHook == "" {
-		for id := range Config.Hooks {
-			Config.DefaultHook = id
-		}
-	}
-
 	return nil
 }
 
@@ -155,17 +224,57 @@ func ParseConfig() error {
 		return err
 	}
 
-	if Config.Port == 0 {
-		Config.Port = 8080
+	err = Config.Lexicon.Validate()
+	if err != nil {
+		return fmt.Errorf("ERROR: invalid global lexicon: %v", err)
 	}
 
-	Config.portString = fmt.Sprintf(":%v", Config.Port)
+	err = ValidateSegmenter(Config.Segmenter)
+	if err != nil {
+		return fmt.Errorf("ERROR: invalid segmenter: %v", err)
+	}
+
+	_, err = mergeOutbound(&Config.Outbound)
+	if err != nil {
+		return fmt.Errorf("ERROR: invalid outbound policy: %v", err)
+	}
+
+	_, err = mergeOutbound(&Config.Callbacks.Outbound)
+	if err != nil {
+		return fmt.Errorf("ERROR: invalid callback outbound policy: %v", err)
+	}
 
-	if Config.DefaultHook == "" {
-		for id := range Config.Hooks {
-			Config.DefaultHook = id
+	if Config.Admin != nil {
+		Config.Admin.token, err = InterpolateSecrets(Config.Admin.Token)
+		if err != nil {
+			return fmt.Errorf("ERROR: invalid admin token: %v", err)
 		}
+		if Config.Admin.token == "" {
+			return fmt.Errorf("ERROR: an admin token must be given to enable the admin API")
+		}
+	}
+
+	err = LoadHooks()
+	if err != nil {
+		return err
 	}
 
+	for id, schedule := range Config.Schedules {
+		err = schedule.Validate()
+		if err != nil {
+			return fmt.Errorf("ERROR: invalid schedule '%v': %v", id, err)
+		}
+		if _, _, err = FindHook(schedule.HookID); err != nil {
+			return fmt.Errorf("ERROR: invalid schedule '%v': %v", id, err)
+		}
+		Config.Schedules[id] = schedule
+	}
+
+	if Config.Port == 0 {
+		Config.Port = 8080
+	}
+
+	Config.portString = fmt.Sprintf(":%v", Config.Port)
+
 	return nil
 }
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// redacted replaces sensitive
+// values shown by dry runs
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are the (lowercase) header
+// name parts whose values dry runs redact
+var sensitiveHeaders = []string{"authorization", "cookie", "token", "secret", "key", "signature", "session", "password"}
+
+// DryRunJSON is the JSON expected by POST
+// /dryrun. It's a TaskJSON giving the record id
+// and params to try, along with either a hook
+// id or a Hook definition to try them with.
+type DryRunJSON struct {
+	TaskJSON
+	Hook json.RawMessage `json:"hook,omitempty"`
+}
+
+// DryRunResponse describes everything a hook
+// request did, step by step. Error is given
+// for whichever step failed.
+type DryRunResponse struct {
+	HookID   string          `json:"hookId,omitempty"`
+	Request  *DryRunRequest  `json:"request,omitempty"`
+	Response *DryRunUpstream `json:"response,omitempty"`
+	Steps    []ExtractStep   `json:"steps"`
+	Text     *string         `json:"text,omitempty"`
+	Error    json.RawMessage `json:"error,omitempty"`
+}
+
+// DryRunRequest is the request sent
+// upstream, with secrets redacted
+type DryRunRequest struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body,omitempty"`
+}
+
+// DryRunUpstream is the upstream's response.
+// Body is at most the first 4KB of the body,
+// and Size the size of the whole body.
+type DryRunUpstream struct {
+	Status    int                 `json:"status"`
+	Headers   map[string][]string `json:"headers"`
+	Body      string              `json:"body"`
+	Size      int                 `json:"size"`
+	Truncated bool                `json:"truncated,omitempty"`
+}
+
+// DryRun makes a hook's request for a task and
+// describes each step along the way. Unlike a
+// task it skips the cache and the hook's
+// retries and circuit breaker, so it always
+// reaches the upstream (unless the outbound
+// policy blocks it.) It isn't counted in the
+// status metrics.
+func (h *Hook) DryRun(id string, j TaskJSON) *DryRunResponse {
+	d := &DryRunResponse{
+		HookID: id,
+		Steps:  []ExtractStep{},
+	}
+
+	req, err := h.NewRequest(j)
+	if err != nil {
+		d.Error = errorJSON(fmt.Errorf(`{"message": "ERROR: unable to build the HOOK request from the configured hook", "hookUrl": "%v", "id": "%v", "error": "%v"}`, h.URL, id, err))
+		return d
+	}
+
+	var resp *UpstreamResponse
+	err = h.allows(id, req.URL)
+	if err == nil {
+		resp, err = h.send(id, req)
+
+		if hookErr, ok := err.(*HookError); ok {
+			resp = hookErr.response
+		}
+		if resp != nil {
+			d.Response = h.describeResponse(resp)
+		}
+	}
+
+	// describe the request after sending it so
+	// auth and signing headers are shown
+	d.Request = h.describeRequest(req)
+	if err != nil {
+		d.Error = errorJSON(err)
+		return d
+	}
+
+	trace := &extractTrace{}
+	r, err := h.extract(id, resp.Body, trace)
+	d.Steps = trace.steps
+	if err != nil {
+		d.Error = errorJSON(err)
+		return d
+	}
+
+	d.Text = &r.Text
+	return d
+}
+
+// describeRequest describes a hook
+// request, redacting secrets
+func (h *Hook) describeRequest(req *http.Request) *DryRunRequest {
+	d := &DryRunRequest{
+		Method:  req.Method,
+		URL:     h.redact(req.URL.String()),
+		Headers: h.redactHeaders(req.Header),
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err == nil {
+			data, _ := ioutil.ReadAll(body)
+			body.Close()
+			d.Body = h.redact(string(data))
+		}
+	}
+
+	return d
+}
+
+// describeResponse describes an upstream
+// response, keeping an excerpt of the body
+func (h *Hook) describeResponse(resp *UpstreamResponse) *DryRunUpstream {
+	d := &DryRunUpstream{
+		Status:  resp.Status,
+		Headers: h.redactHeaders(resp.Header),
+		Body:    string(resp.Body),
+		Size:    len(resp.Body),
+	}
+
+	if len(d.Body) > maxExcerptSize {
+		d.Body = d.Body[:maxExcerptSize]
+		d.Truncated = true
+	}
+
+	return d
+}
+
+// redactHeaders copies headers, redacting the
+// values of sensitive ones and any secrets
+func (h *Hook) redactHeaders(header http.Header) map[string][]string {
+	redactedHeader := map[string][]string{}
+	for name, values := range header {
+		sensitive := h.Signing != nil && strings.EqualFold(name, h.Signing.Header)
+		for _, part := range sensitiveHeaders {
+			if strings.Contains(strings.ToLower(name), part) {
+				sensitive = true
+			}
+		}
+
+		redactedHeader[name] = make([]string, len(values))
+		for i, value := range values {
+			if sensitive {
+				value = redacted
+			}
+			redactedHeader[name][i] = h.redact(value)
+		}
+	}
+
+	return redactedHeader
+}
+
+// HandleDryRun makes a hook request for a
+// task without analyzing it, describing each
+// step of getting the text (see DryRun)
+func HandleDryRun(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error reading request body", "error": "%v"}`, err.Error())))
+		log.Printf("POST /dryrun > ERROR: couldn't read request body\n\t%v\n", err)
+		return
+	}
+
+	j := DryRunJSON{}
+	err = json.Unmarshal(data, &j)
+	if err != nil {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error unmarshalling given JSON into expected format", "error": "%v"}`, err.Error())))
+		log.Printf("POST /dryrun > ERROR: error unmarshalling given JSON\n\t%v\n", err)
+		return
+	}
+
+	var (
+		id   string
+		hook Hook
+	)
+	if len(j.Hook) != 0 {
+		id = j.HookID
+		hook, err = parseAPIHook(j.Hook)
+		if err != nil {
+			message, _ := json.Marshal(err.Error())
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: invalid hook given", "error": %s}`, message)))
+			log.Printf("POST /dryrun > ERROR: invalid hook given\n\t%v\n", err)
+			return
+		}
+	} else {
+		id, hook, err = FindHook(j.HookID)
+		if err != nil {
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to find hook", "error": %v}`, err)))
+			log.Printf("POST /dryrun > ERROR: unable to find hook\n\t%v\n", err)
+			return
+		}
+	}
+
+	resp, err := json.Marshal(hook.DryRun(id, j.TaskJSON))
+	if err != nil {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error marshalling dry run", "error": "%v"}`, err.Error())))
+		log.Printf("POST /dryrun > ERROR: error marshalling dry run\n\t%v\n", err)
+		return
+	}
+
+	log.Printf("POST /dryrun [hook = %v, id = %v]\n", id, j.ID)
+
+	r.WriteHeader(http.StatusOK)
+	r.Write(resp)
+}
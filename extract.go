@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cdipaolo/sentiment"
+)
+
+// HookResponse holds what was extracted
+// from the response to a hook request. Text
+// is the whole text to analyze, Matches holds
+// each text matched by the hook's key when it
+// scores them separately, Series holds any
+// time series data, and Items holds the items
+// of collection hooks. Series times are in
+// milliseconds, unless RawTimes is set because
+// they're kept as the upstream gave them.
+type HookResponse struct {
+	Series   []TimeSeries
+	Items    []CollectionItem
+	Text     string
+	Matches  []string
+	Language sentiment.Language
+	RawTimes bool
+}
+
+// TimeFields holds the JSONPath expressions
+// (relative to each time series entry) of the
+// start, end, text and speaker of the entry.
+// They default to the "start", "end", "text"
+// and "speaker" fields. Entries without a
+// speaker are left without one.
+type TimeFields struct {
+	Start   string `json:"start,omitempty"`
+	End     string `json:"end,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Speaker string `json:"speaker,omitempty"`
+
+	start, end, text, speaker *JSONPath
+}
+
+// compile compiles the time field paths
+// with the hook's compiler, filling in
+// the defaults
+func (t *TimeFields) compile(compile func(string) (*JSONPath, error)) error {
+	var err error
+	fields := []struct {
+		expr *string
+		path **JSONPath
+		def  string
+	}{
+		{&t.Start, &t.start, "start"},
+		{&t.End, &t.end, "end"},
+		{&t.Text, &t.text, "text"},
+		{&t.Speaker, &t.speaker, "speaker"},
+	}
+
+	for _, f := range fields {
+		if *f.expr == "" {
+			*f.expr = f.def
+		}
+
+		*f.path, err = compile(*f.expr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Extract pulls the text (and time series data
+// for time hooks) out of the body of a response
+// to a hook request
+func (h *Hook) Extract(id string, data []byte) (*HookResponse, error) {
+	return h.extract(id, data, nil)
+}
+
+// ExtractStep is the result of one step of
+// extracting the text from a hook response,
+// as shown by dry runs
+type ExtractStep struct {
+	Step   string      `json:"step"`
+	Detail string      `json:"detail,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// extractTrace records extraction steps.
+// A nil trace records nothing.
+type extractTrace struct {
+	steps []ExtractStep
+}
+
+// add records a step
+func (t *extractTrace) add(step, detail string, result interface{}) {
+	if t != nil {
+		t.steps = append(t.steps, ExtractStep{
+			Step:   step,
+			Detail: detail,
+			Result: result,
+		})
+	}
+}
+
+// extract does the work of Extract,
+// recording each step in the trace
+func (h *Hook) extract(id string, data []byte, trace *extractTrace) (*HookResponse, error) {
+	r := &HookResponse{
+		Text:     string(data),
+		Language: h.Language,
+	}
+
+	if h.Format == FormatFeed {
+		items, err := parseFeed(data)
+		if err != nil {
+			return nil, fmt.Errorf(`{"message": "ERROR: could not parse feed from HOOK request", "hook": "%v", "error": "%v"}`, id, err)
+		}
+		trace.add("feed", fmt.Sprintf("%v feed entries were read", len(items)), items)
+
+		r.Items = items
+		r.Text = collectionText(items)
+		return r, nil
+	}
+
+	if IsTranscriptFormat(h.Format) {
+		series, err := ParseTranscript(h.Format, data)
+		if err != nil {
+			return nil, fmt.Errorf(`{"message": "ERROR: could not parse transcript from HOOK request", "hook": "%v", "format": "%v", "error": "%v"}`, id, h.Format, err)
+		}
+		trace.add("transcript", fmt.Sprintf("%v time series entries were read from the %v transcript", len(series), h.Format), series)
+
+		r.Series = series
+		r.Text = TurnTimeSeriesIntoText(series)
+		return r, nil
+	}
+
+	if h.Key == "" && !h.Time && h.Collection == nil {
+		trace.add("raw", "the hook has no key, so the whole body is the text", nil)
+		return r, nil
+	}
+
+	body, err := h.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf(`{"message": "ERROR: could not unmarshal body from HOOK request", "hook": "%v", "format": "%v", "error": "%v"}`, id, h.Format, err)
+	}
+	trace.add("parse", fmt.Sprintf("the body was parsed as %v", strings.ToUpper(h.Format)), describeJSON(body))
+
+	if h.Collection != nil {
+		r.Items, err = h.extractCollection(id, body)
+		if err != nil {
+			return nil, err
+		}
+		trace.add("collection", fmt.Sprintf("%v items were read", len(r.Items)), r.Items)
+
+		r.Text = collectionText(r.Items)
+		return r, nil
+	}
+
+	if !h.Time {
+		matches := h.keyPath.Find(body)
+		trace.add("key", fmt.Sprintf("the key %v matched %v values", h.Key, len(matches)), matches)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf(`{"message": "ERROR: could not get text with the given key from HOOK request", "hook": "%v", "expectedId": "%v"}`, id, h.Key)
+		}
+
+		texts := make([]string, len(matches))
+		for i := range matches {
+			text, ok := textValue(matches[i])
+			if !ok {
+				return nil, fmt.Errorf(`{"message": "ERROR: could not assert HOOK request body to type string", "hook": "%v", "key": "%v", "match": %v}`, id, h.Key, i)
+			}
+			texts[i] = text
+		}
+
+		r.Text = strings.Join(texts, " ")
+		if h.Separate {
+			r.Matches = texts
+		}
+		return r, nil
+	}
+
+	// JSON time series without a key are
+	// expected to be the top level array, and
+	// are given in milliseconds (not seconds)
+	// for legacy reasons
+	entries := []interface{}{body}
+	scale := 1.0
+	if h.Format != FormatJSON {
+		scale = 1000.0
+	}
+	if h.Key != "" {
+		entries = h.keyPath.Find(body)
+		scale = 1000.0
+		trace.add("key", fmt.Sprintf("the key %v matched %v values", h.Key, len(entries)), entries)
+	}
+	if len(entries) == 1 {
+		if arr, ok := entries[0].([]interface{}); ok {
+			entries = arr
+		}
+	}
+	if h.Key == "" && len(entries) == 1 {
+		if _, ok := entries[0].([]interface{}); !ok {
+			return nil, fmt.Errorf(`{"message": "ERROR: could not unmarshal body from HOOK request into type []TimeSeries", "hook": "%v"}`, id)
+		}
+	}
+
+	r.RawTimes = scale == 1.0
+	r.Series = []TimeSeries{}
+	for i := range entries {
+		entry, err := h.TimeFields.entry(entries[i])
+		if err != nil {
+			return nil, fmt.Errorf(`{"message": "ERROR: could not read time series entry from HOOK request", "hook": "%v", "key": "%v", "entry": %v, "error": "%v"}`, id, h.Key, i, err)
+		}
+
+		entry.Start *= scale
+		entry.End *= scale
+		r.Series = append(r.Series, entry)
+	}
+
+	trace.add("timeSeries", fmt.Sprintf("%v time series entries were read", len(r.Series)), r.Series)
+
+	r.Text = TurnTimeSeriesIntoText(r.Series)
+	return r, nil
+}
+
+// describeJSON summarizes a parsed
+// JSON document for dry runs
+func describeJSON(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return fmt.Sprintf("an object with the keys %v", keys)
+	case []interface{}:
+		return fmt.Sprintf("an array of %v values", len(v))
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+
+	return "null"
+}
+
+// entry reads a single time series entry.
+// Missing fields are left zeroed.
+func (t *TimeFields) entry(v interface{}) (TimeSeries, error) {
+	var (
+		entry TimeSeries
+		ok    bool
+	)
+
+	if m := t.start.Find(v); len(m) != 0 {
+		if entry.Start, ok = numberValue(m[0]); !ok {
+			return entry, fmt.Errorf("start '%v' is not a number", t.Start)
+		}
+	}
+	if m := t.end.Find(v); len(m) != 0 {
+		if entry.End, ok = numberValue(m[0]); !ok {
+			return entry, fmt.Errorf("end '%v' is not a number", t.End)
+		}
+	}
+	if m := t.text.Find(v); len(m) != 0 {
+		if entry.Text, ok = textValue(m[0]); !ok {
+			return entry, fmt.Errorf("text '%v' is not a string", t.Text)
+		}
+	}
+	if m := t.speaker.Find(v); len(m) != 0 {
+		if entry.Speaker, ok = textValue(m[0]); !ok {
+			return entry, fmt.Errorf("speaker '%v' is not a string", t.Speaker)
+		}
+	}
+
+	return entry, nil
+}
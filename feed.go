@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// feedEntries find the entries of RSS 2.0,
+	// RSS 1.0 (RDF) and Atom feeds parsed by
+	// parseXML
+	feedEntries = []*JSONPath{
+		mustCompileJSONPath("$.rss[*].channel[*].item[*]"),
+		mustCompileJSONPath("$.RDF[*].item[*]"),
+		mustCompileJSONPath("$.feed[*].entry[*]"),
+	}
+
+	// htmlTag matches the tags within
+	// HTML feed content
+	htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+	// feedTimeLayouts are the layouts feed
+	// dates are given in. RSS uses RFC 822
+	// dates (with or without the weekday or
+	// seconds) and Atom uses RFC 3339.
+	feedTimeLayouts = []string{
+		time.RFC3339,
+		time.RFC1123Z,
+		time.RFC1123,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 MST",
+		"Mon, 2 Jan 2006 15:04 -0700",
+		"2 Jan 2006 15:04:05 -0700",
+		"2 Jan 2006 15:04:05 MST",
+	}
+)
+
+// mustCompileJSONPath compiles a
+// JSONPath known to be valid
+func mustCompileJSONPath(expr string) *JSONPath {
+	p, err := CompileJSONPath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// parseFeed reads the entries of an RSS or
+// Atom feed as collection items. Each item's
+// text is its title and content (or summary)
+// with any HTML removed, its id is its guid
+// (or link) and its time is when it was
+// published (or last updated.)
+func parseFeed(data []byte) ([]CollectionItem, error) {
+	doc, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	root, _ := doc.(map[string]interface{})
+	if root["rss"] == nil && root["RDF"] == nil && root["feed"] == nil {
+		return nil, fmt.Errorf("the document is not an RSS or Atom feed")
+	}
+
+	var entries []interface{}
+	for _, path := range feedEntries {
+		entries = append(entries, path.Find(doc)...)
+	}
+
+	items := []CollectionItem{}
+	for _, entry := range entries {
+		item := CollectionItem{
+			Title: feedText(feedField(entry, "title")),
+		}
+
+		body := feedText(feedField(entry, "encoded", "content", "description", "summary"))
+		item.Text = strings.TrimSpace(item.Title + "\n" + body)
+
+		if id := feedField(entry, "guid", "id"); id != "" {
+			item.ID = id
+		} else if link := feedLink(entry); link != "" {
+			item.ID = link
+		}
+
+		if t, ok := parseFeedTime(feedField(entry, "pubDate", "published", "date", "updated")); ok {
+			item.Time = &t
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// feedField returns the text of the first
+// of the named child elements an entry has
+func feedField(entry interface{}, names ...string) string {
+	fields, ok := entry.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	for _, name := range names {
+		children, _ := fields[name].([]interface{})
+		for _, child := range children {
+			if text, ok := textValue(child); ok && text != "" {
+				return text
+			}
+		}
+	}
+
+	return ""
+}
+
+// feedLink returns the link of an entry,
+// which is the text of RSS links and the
+// href of Atom links (preferring the
+// rel="alternate" link)
+func feedLink(entry interface{}) string {
+	fields, _ := entry.(map[string]interface{})
+	links, _ := fields["link"].([]interface{})
+
+	link := ""
+	for _, l := range links {
+		switch l := l.(type) {
+		case string:
+			if l != "" {
+				return l
+			}
+		case map[string]interface{}:
+			href, _ := l["@href"].(string)
+			if rel, _ := l["@rel"].(string); href != "" && (rel == "" || rel == "alternate") {
+				return href
+			}
+			if link == "" {
+				link = href
+			}
+		}
+	}
+
+	return link
+}
+
+// feedText strips the HTML out of feed
+// text, collapsing its whitespace
+func feedText(s string) string {
+	s = html.UnescapeString(htmlTag.ReplaceAllString(s, " "))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// parseFeedTime parses a feed date
+func parseFeedTime(s string) (time.Time, bool) {
+	for _, layout := range feedTimeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t.UTC(), true
+		}
+	}
+
+	return time.Time{}, false
+}
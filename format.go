@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// FormatJSON reads hook responses as JSON
+	// (or plain text for hooks without a key)
+	FormatJSON = "json"
+
+	// FormatXML reads hook responses as XML,
+	// selecting text with XPath-like keys
+	FormatXML = "xml"
+
+	// FormatCSV reads hook responses as CSV,
+	// selecting text by column
+	FormatCSV = "csv"
+
+	// FormatFeed reads hook responses as RSS
+	// or Atom feeds, analyzing each entry
+	// as a collection item
+	FormatFeed = "feed"
+)
+
+// CSVConfig configures how CSV hook responses
+// are read. Delimiter is the single character
+// between fields (defaults to ",".) The first
+// row names the columns unless NoHeader is
+// given, in which case the columns are
+// numbered from "1".
+type CSVConfig struct {
+	Delimiter string `json:"delimiter,omitempty"`
+	NoHeader  bool   `json:"noHeader,omitempty"`
+
+	delimiter rune
+}
+
+// prepare validates the CSV
+// config, filling in defaults
+func (c *CSVConfig) prepare() error {
+	if c.Delimiter == "" {
+		c.Delimiter = ","
+	}
+
+	if utf8.RuneCountInString(c.Delimiter) != 1 {
+		return fmt.Errorf("the delimiter must be a single character")
+	}
+	c.delimiter, _ = utf8.DecodeRuneInString(c.Delimiter)
+	if c.delimiter == '"' || c.delimiter == '\r' || c.delimiter == '\n' {
+		return fmt.Errorf("'%v' can't be used as a delimiter", c.Delimiter)
+	}
+
+	return nil
+}
+
+// prepareFormat validates the hook's
+// format and the options used with it
+func (h *Hook) prepareFormat() error {
+	switch h.Format {
+	case "":
+		h.Format = FormatJSON
+	case FormatJSON, FormatXML:
+	case FormatCSV:
+		if h.CSV == nil {
+			h.CSV = &CSVConfig{}
+		}
+		err := h.CSV.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid csv: %v", err)
+		}
+	case FormatFeed:
+		if h.Key != "" || h.Time || h.Collection != nil {
+			return fmt.Errorf("feed hooks can't give a key, time series or collection")
+		}
+	case FormatSRT, FormatVTT, FormatWhisper:
+		if h.Key != "" || h.Collection != nil {
+			return fmt.Errorf("%v hooks can't give a key or collection", h.Format)
+		}
+	default:
+		return fmt.Errorf("unknown format '%v' (expected 'json', 'xml', 'csv', 'feed', 'srt', 'vtt' or 'whisper')", h.Format)
+	}
+
+	if (h.Format == FormatXML || h.Format == FormatCSV) && h.Key == "" && !h.Time && h.Collection == nil {
+		return fmt.Errorf("%v hooks must give a key", h.Format)
+	}
+
+	return nil
+}
+
+// compilePath compiles a path into a hook
+// response: an XPath-like selector for XML
+// hooks and a JSONPath otherwise
+func (h *Hook) compilePath(expr string) (*JSONPath, error) {
+	if h.Format == FormatXML {
+		return CompileXPath(expr)
+	}
+	return CompileJSONPath(expr)
+}
+
+// compileKey compiles the hook's key. The
+// keys of CSV hooks name a column, and
+// select it from every row.
+func (h *Hook) compileKey() (*JSONPath, error) {
+	if h.Format == FormatCSV && !strings.HasPrefix(h.Key, "$") {
+		return &JSONPath{
+			expr: h.Key,
+			steps: []pathStep{
+				{kind: stepWildcard},
+				{kind: stepField, name: h.Key},
+			},
+		}, nil
+	}
+
+	return h.compilePath(h.Key)
+}
+
+// decode parses the body of a hook response in
+// the hook's format, giving the same kind of
+// values as encoding/json
+func (h *Hook) decode(data []byte) (interface{}, error) {
+	switch h.Format {
+	case FormatXML:
+		return parseXML(data)
+	case FormatCSV:
+		return parseCSV(data, h.CSV)
+	}
+
+	var body interface{}
+	err := json.Unmarshal(data, &body)
+	return body, err
+}
+
+// parseCSV decodes a CSV document into an
+// array of rows, each an object from the
+// column names to the row's values
+func parseCSV(data []byte, c *CSVConfig) (interface{}, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.Comma = c.delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	if !c.NoHeader && len(records) != 0 {
+		columns, records = records[0], records[1:]
+	}
+
+	rows := make([]interface{}, len(records))
+	for i, record := range records {
+		row := map[string]interface{}{}
+		for j, value := range record {
+			name := strconv.Itoa(j + 1)
+			if j < len(columns) {
+				name = columns[j]
+			}
+			row[name] = value
+		}
+		rows[i] = row
+	}
+
+	return rows, nil
+}
+
+// textValue returns the text of a value found
+// within a hook response: a string, or the
+// text of an XML element with attributes
+func textValue(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case map[string]interface{}:
+		if text, ok := t[xmlText].(string); ok {
+			return text, true
+		}
+	}
+
+	return "", false
+}
+
+// numberValue returns the number held by a
+// value found within a hook response: a JSON
+// number, or text holding a number (as XML and
+// CSV values always are)
+func numberValue(v interface{}) (float64, bool) {
+	if n, ok := v.(float64); ok {
+		return n, true
+	}
+
+	text, ok := textValue(v)
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	return n, err == nil
+}
@@ -8,19 +8,20 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
+	"sync/atomic"
 
 	"github.com/cdipaolo/sentiment"
 )
 
+// count and hookCount are updated from
+// handlers and background workers at once,
+// so they're only used through sync/atomic
 var (
-	client    *http.Client
 	count     int64
 	hookCount int64
 )
 
 func init() {
-	client = &http.Client{}
 	count = 0
 	hookCount = 0
 }
@@ -28,7 +29,9 @@ func init() {
 // HandleStatus is a simple health-check endpoint
 // that will tell the user the total number of
 // successful analyses and the number of successful
-// hooked requests (a subset of the former) made
+// hooked requests (a subset of the former) made,
+// as well as the state of each hook's circuit
+// breaker
 func HandleStatus(r http.ResponseWriter, req *http.Request) {
 	// have to check that the URL is
 	// exactly /
@@ -40,15 +43,20 @@ func HandleStatus(r http.ResponseWriter, req *http.Request) {
 	r.Header().Add("Content-Type", "application/json")
 	r.WriteHeader(http.StatusOK)
 
-	// send the total successful count
-	// and total error count
+	breakers, _ := json.Marshal(BreakerStates())
+	successful, hooked := atomic.LoadInt64(&count), atomic.LoadInt64(&hookCount)
+
+	// send the total successful count,
+	// total error count, and the state
+	// of any hook circuit breakers
 	r.Write([]byte(fmt.Sprintf(`{
 		"status": "Up",
 		"totalSuccessfulAnalyses": %v,
-		"hookedRequests": %v
-	}`, count, hookCount)))
+		"hookedRequests": %v,
+		"breakers": %s
+	}`, successful, hooked, breakers)))
 
-	log.Printf("GET / [totalSuccessfulAnalyses = %v]\n", count)
+	log.Printf("GET / [totalSuccessfulAnalyses = %v]\n", successful)
 }
 
 // HandleSentiment takes in a POST with JSON
@@ -82,7 +90,32 @@ func HandleSentiment(r http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	analysis := model.SentimentAnalysis(j.Text, j.Language)
+	err = j.Lexicon.Validate()
+	if err != nil {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: invalid lexicon given", "error": "%v"}`, err.Error())))
+		log.Printf("POST /analyze > ERROR: invalid lexicon given\n\t%v\n", err)
+		return
+	}
+
+	lex := MergeLexicons(Config.Lexicon, j.Lexicon)
+	analysis := Analyze(j.Text, j.Language, lex)
+	AddHighlights(analysis, j.Text, lex, j.Highlights)
+
+	if WantsHTML(req) {
+		err = ServeHeatmap(r, analysis, j.Text, lex, nil, "")
+		if err != nil {
+			r.WriteHeader(http.StatusInternalServerError)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to render sentiment analysis as HTML", "error": "%v"}`, err.Error())))
+			log.Printf("POST /analyze > ERROR: unable to render sentiment analysis as HTML\n\t%v\n", err)
+			return
+		}
+
+		atomic.AddInt64(&count, 1)
+		log.Printf("POST /analyze [len(text) = %v, html]\n", len(j.Text))
+		return
+	}
+
 	resp, err := json.Marshal(analysis)
 	if err != nil {
 		r.WriteHeader(http.StatusInternalServerError)
@@ -94,7 +127,7 @@ func HandleSentiment(r http.ResponseWriter, req *http.Request) {
 	r.WriteHeader(http.StatusOK)
 	r.Write(resp)
 
-	count++
+	atomic.AddInt64(&count, 1)
 	log.Printf("POST /analyze [len(text) = %v]\n", len(j.Text))
 }
 
@@ -132,31 +165,82 @@ func HandleHookedRequest(r http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if j.Callback != nil {
+		err = j.Callback.Validate()
+		if err != nil {
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: invalid callback given", "error": "%v"}`, err.Error())))
+			log.Printf("POST /task > ERROR: invalid callback given\n\t%v\n", err)
+			return
+		}
+	}
+
+	if len(j.IDs) != 0 {
+		if j.Async || j.Callback != nil {
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(`{"message": "ERROR: batches of recordingIds can't be run asynchronously"}`))
+			log.Printf("POST /task > ERROR: asynchronous batch requested\n")
+			return
+		}
+
+		HandleBatch(r, req, j)
+		return
+	}
+
+	if j.Async || j.Callback != nil {
+		job, err := jobs.Submit(j)
+		if err != nil {
+			r.WriteHeader(http.StatusServiceUnavailable)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to queue task", "error": "%v"}`, err.Error())))
+			log.Printf("POST /task > ERROR: unable to queue task\n\t%v\n", err)
+			return
+		}
+
+		resp, err := json.Marshal(job)
+		if err != nil {
+			r.WriteHeader(http.StatusInternalServerError)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to marshal job into JSON", "error": "%v"}`, err.Error())))
+			log.Printf("POST /task > ERROR: error marshalling job into JSON\n\t%v\n", err)
+			return
+		}
+
+		r.Header().Set("Location", "/task/"+job.ID)
+		r.WriteHeader(http.StatusAccepted)
+		r.Write(resp)
+
+		log.Printf("POST /task [async, job = %v]\n", job.ID)
+		return
+	}
+
 	// * Perform the GET hook * //
-	series, text, lang, err := GetHookResponse(j)
+	result, err := RunTask(j)
 	if err != nil {
-		r.WriteHeader(http.StatusInternalServerError)
+		r.WriteHeader(ErrorStatus(err))
 		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to get text from hook request with configured parameters", "error": %v}`, err)))
 		log.Printf("POST /task > ERROR: error getting hooked response\n\t%v\n", err)
 		return
 	}
 
-	resp := []byte{}
-
-	analysis := model.SentimentAnalysis(text, lang)
-
-	if series == nil {
-		resp, err = json.Marshal(analysis)
-	} else {
-		for i := range series {
-			series[i].Score = model.SentimentAnalysis(series[i].Text, lang).Score
+	if WantsHTML(req) {
+		unit := "ms"
+		if result.RawTimes {
+			unit = ""
+		}
+		err = ServeHeatmap(r, result.Analysis, result.Text, result.Lexicon, result.Series, unit)
+		if err != nil {
+			r.WriteHeader(http.StatusInternalServerError)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to render sentiment analysis as HTML", "error": "%v"}`, err.Error())))
+			log.Printf("POST /task > ERROR: unable to render sentiment analysis as HTML\n\t%v\n", err)
+			return
 		}
-		resp, err = json.Marshal(TimeSeriesResponse{
-			Metadata: analysis,
-			Series:   series,
-		})
+
+		atomic.AddInt64(&hookCount, 1)
+		atomic.AddInt64(&count, 1)
+		log.Printf("POST /task [len(text) = %v, html]\n", len(result.Text))
+		return
 	}
 
+	resp, err := json.Marshal(result.Response())
 	if err != nil {
 		r.WriteHeader(http.StatusInternalServerError)
 		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to marshal sentiment analysis into JSON", "error": "%v"}`, err.Error())))
@@ -167,9 +251,100 @@ func HandleHookedRequest(r http.ResponseWriter, req *http.Request) {
 	r.WriteHeader(http.StatusOK)
 	r.Write(resp)
 
-	hookCount++
-	count++
-	log.Printf("POST /task [len(text) = %v]\n", len(text))
+	atomic.AddInt64(&hookCount, 1)
+	atomic.AddInt64(&count, 1)
+	log.Printf("POST /task [len(text) = %v]\n", len(result.Text))
+}
+
+// TaskResult holds the outcome of running
+// a hooked task: the analysis of the hook's
+// text, any time series data, and the text
+// and lexicon the analysis was run with
+type TaskResult struct {
+	Analysis  *AnalysisResponse
+	Series    []TimeSeries
+	RawTimes  bool
+	Items     []CollectionItem
+	Aggregate CollectionAggregate
+	Text      string
+	Lexicon   Lexicon
+}
+
+// Response returns the value sent back to
+// the API consumer for a task: the analysis,
+// a TimeSeriesResponse if the hook returned
+// time series data, or a CollectionResponse
+// for collection hooks
+func (t *TaskResult) Response() interface{} {
+	if t.Items != nil {
+		return CollectionResponse{
+			Metadata:  t.Analysis,
+			Items:     t.Items,
+			Aggregate: t.Aggregate,
+		}
+	}
+
+	if t.Series == nil {
+		return t.Analysis
+	}
+
+	return TimeSeriesResponse{
+		Metadata: t.Analysis,
+		Series:   t.Series,
+	}
+}
+
+// RunTask performs the hook request for a
+// task and analyzes the returned text (and
+// each time series bucket, if any)
+func RunTask(j TaskJSON) (*TaskResult, error) {
+	// the hook is looked up once so the whole
+	// task uses the same definition, even if
+	// the hook is changed while it's running
+	id, hook, err := FindHook(j.HookID)
+	if err != nil {
+		return nil, err
+	}
+
+	return runHookTask(id, &hook, j)
+}
+
+// runHookTask does the work of RunTask with
+// a hook which was already looked up, so
+// every task in a batch uses the same hook
+func runHookTask(id string, hook *Hook, j TaskJSON) (*TaskResult, error) {
+	r, err := hook.Fetch(id, j)
+	if err != nil {
+		return nil, err
+	}
+	series, text, lang := r.Series, r.Text, r.Language
+
+	lex := MergeLexicons(Config.Lexicon, hook.Lexicon)
+
+	analysis := Analyze(text, lang, lex)
+	AddHighlights(analysis, text, lex, j.Highlights)
+
+	for _, match := range r.Matches {
+		analysis.Matches = append(analysis.Matches, Analyze(match, lang, lex))
+	}
+
+	for i := range series {
+		series[i].Score = Analyze(series[i].Text, lang, lex).Score
+	}
+
+	result := &TaskResult{
+		Analysis: analysis,
+		Series:   series,
+		RawTimes: r.RawTimes,
+		Items:    r.Items,
+		Text:     text,
+		Lexicon:  lex,
+	}
+	if r.Items != nil {
+		result.Aggregate = AnalyzeCollection(r.Items, lang, lex)
+	}
+
+	return result, nil
 }
 
 // GetHookResponse takes in a TaskJSON and
@@ -179,99 +354,78 @@ func HandleHookedRequest(r http.ResponseWriter, req *http.Request) {
 // within the hook declaration (and expecting
 // plain text result if the param is blank
 func GetHookResponse(j TaskJSON) ([]TimeSeries, string, sentiment.Language, error) {
-	id := Config.DefaultHook
-	if j.HookID != "" {
-		id = j.HookID
+	r, err := FetchHook(j)
+	if err != nil {
+		return nil, "", sentiment.NoLanguage, err
 	}
 
-	hook, ok := Config.Hooks[id]
-	if !ok {
-		return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: hook given was not found in your configured hooks!", "hookId": "%v", "defaultHook": "%v"}`, id, Config.DefaultHook)
-	}
+	return r.Series, r.Text, r.Language, nil
+}
 
-	url, err := url.Parse(fmt.Sprintf(hook.URL, j.ID))
+// FetchHook performs the hook request for a
+// task (through the cache, if there is one)
+// and extracts the text, matches, and time
+// series data from the response
+func FetchHook(j TaskJSON) (*HookResponse, error) {
+	id, hook, err := FindHook(j.HookID)
 	if err != nil {
-		return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: unable to format the given ID into the configured hook ID", "hookUrl": "%v", "id":"%v"}`, hook.URL, id)
+		return nil, err
 	}
 
-	request := &http.Request{
-		Method: "GET",
-		Header: http.Header(hook.Headers),
-		URL:    url,
-	}
+	return hook.Fetch(id, j)
+}
 
-	resp, err := client.Do(request)
+// Fetch performs the hook request for a task
+// with this hook definition, which has the
+// given id. Every page is fetched for hooks
+// with pagination.
+func (h *Hook) Fetch(id string, j TaskJSON) (*HookResponse, error) {
+	request, err := h.NewRequest(j)
 	if err != nil {
-		return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not complete HOOK request", "hook": "%v", "error": "%v"}`, id, err)
+		return nil, fmt.Errorf(`{"message": "ERROR: unable to build the HOOK request from the configured hook", "hookUrl": "%v", "id": "%v", "error": "%v"}`, h.URL, id, err)
 	}
 
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil && err != io.EOF {
-		return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not read the body from HOOK GET request", "hook": "%v", "error": "%v"}`, id, err)
+	if h.Pagination != nil {
+		return h.fetchPages(id, j, request)
 	}
 
-	text := string(data)
-	var timeSeries []TimeSeries
-	if hook.Key != "" && !hook.Time {
-		bod := make(map[string]interface{})
-		err = json.Unmarshal(data, &bod)
-		if err != nil {
-			return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not unmarshal body from HOOK GET request", "hook": "%v", "error": "%v"}`, id, err)
-		}
-
-		tmp, ok := bod[hook.Key]
-		if !ok {
-			return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not get text with the given key from HOOK GET request", "hook": "%v", "expectedId": "%v"}`, id, hook.Key)
-		}
-
-		text, ok = tmp.(string)
-		if !ok {
-			return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not assert HOOK GET request body to type string", "hook": "%v"}`, id)
-		}
+	resp, err := h.fetch(id, fetchKey(id, j), request)
+	if err != nil {
+		return nil, err
 	}
-	if hook.Key != "" && hook.Time {
-		bod := make(map[string]interface{})
-		err = json.Unmarshal(data, &bod)
-		if err != nil {
-			return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not unmarshal body from HOOK GET request", "hook": "%v", "error": "%v"}`, id, err)
-		}
 
-		// have to marshal into JSON and back out
-		// of JSON to ignore the extra fields possibly
-		// in the interface{} for type assertion
-		timeSeriesJSON, err := json.Marshal(bod[hook.Key])
-		if err != nil {
-			return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not marshal (what should be an) array with the given key from HOOK GET request", "hook": "%v", "expectedId": "%v", "body": %v}`, id, hook.Key, string(data))
-		}
+	return h.Extract(id, resp.Body)
+}
 
-		// and back out again!
-		t := []TimeSeriesRequest{}
-		err = json.Unmarshal(timeSeriesJSON, &t)
-		if err != nil {
-			return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not unmarshal series with the given key from HOOK GET request", "hook": "%v", "series": %v}`, id, hook.Key, string(timeSeriesJSON))
-		}
+// fetch performs a single hook request,
+// through the cache if there is one and
+// the key isn't blank
+func (h *Hook) fetch(id, key string, req *http.Request) (*UpstreamResponse, error) {
+	fetch := func(req *http.Request) (*UpstreamResponse, error) {
+		return h.Do(id, req)
+	}
 
-		timeSeries = []TimeSeries{}
-		for i := range t {
-			timeSeries = append(timeSeries, TimeSeries{
-				Start: t[i].Start * 1000.0,
-				End:   t[i].End * 1000.0,
-				Text:  t[i].Text,
-			})
-		}
+	if cache != nil && key != "" {
+		return cache.CachedFetch(key, req, fetch)
+	}
+	return fetch(req)
+}
 
-		text = TurnTimeSeriesIntoText(timeSeries)
+// FindHook returns the configured hook with
+// the given id, falling back to the default
+// hook when the id is blank
+func FindHook(hookID string) (string, Hook, error) {
+	id := Config.DefaultHook
+	if hookID != "" {
+		id = hookID
 	}
-	if hook.Key == "" && hook.Time {
-		err = json.Unmarshal(data, &timeSeries)
-		if err != nil {
-			return nil, "", sentiment.NoLanguage, fmt.Errorf(`{"message": "ERROR: could not unmarshal body from HOOK GET request into type []TimeSeries", "hook": "%v", "error": "%v", body: %v}`, id, err, string(data))
-		}
 
-		text = TurnTimeSeriesIntoText(timeSeries)
+	hook, ok := CurrentHooks()[id]
+	if !ok {
+		return id, Hook{}, fmt.Errorf(`{"message": "ERROR: hook given was not found in your configured hooks!", "hookId": "%v", "defaultHook": "%v"}`, id, Config.DefaultHook)
 	}
 
-	return timeSeries, text, hook.Language, nil
+	return id, hook, nil
 }
 
 // TurnTimeSeriesIntoText compiles all the text values
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// heatmapTemplate renders an analyzed document
+// as a self-contained page (no scripts, fonts,
+// or stylesheets are loaded) so it can be
+// saved and viewed offline.
+var heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head>
+<meta charset="utf-8">
+<title>Sentiment Analysis</title>
+<style>
+body { font-family: Georgia, serif; max-width: 50em; margin: 2em auto; padding: 0 1em; color: #222; line-height: 1.6; }
+h1 { font-size: 1.4em; }
+.score { font-family: monospace; }
+.legend { display: flex; align-items: center; gap: 0.5em; font-size: 0.85em; margin-bottom: 1.5em; }
+.legend .bar { width: 12em; height: 0.8em; border: 1px solid #ccc; background: linear-gradient(to right, #e0584f, #ffffff, #4fae5a); }
+.sentence { display: block; border-left: 0.4em solid; padding: 0.2em 0.6em; margin: 0.4em 0; }
+.word { padding: 0 0.1em; border-radius: 0.2em; }
+table { border-collapse: collapse; font-size: 0.9em; margin-top: 1.5em; }
+td, th { padding: 0.2em 0.6em; text-align: left; border-bottom: 1px solid #eee; }
+</style>
+</head>
+<body>
+<h1>Sentiment: {{if eq .Score 1}}Positive{{else}}Negative{{end}} <span class="score">(score {{.Score}}, confidence {{printf "%.3f" .Confidence}})</span></h1>
+<div class="legend"><span>negative</span><span class="bar"></span><span>positive</span></div>
+{{range .Sentences}}<span class="sentence" style="border-color: {{.Color}}" title="confidence {{printf "%.3f" .Confidence}}">{{range .Words}}<span class="word" style="background-color: {{.Color}}" title="{{printf "%.3f" .Positive}}">{{.Word}}</span> {{end}}</span>
+{{end}}{{if .Series}}<table>
+<tr><th>start{{with .TimeUnit}} ({{.}}){{end}}</th><th>end{{with .TimeUnit}} ({{.}}){{end}}</th><th>score</th><th>text</th></tr>
+{{range .Series}}<tr style="background-color: {{.Color}}"><td>{{.Start}}</td><td>{{.End}}</td><td>{{.Score}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// heatmap holds the values the heatmap
+// template is rendered with
+type heatmap struct {
+	Language   string
+	Score      uint8
+	Confidence float64
+	Sentences  []heatmapSentence
+	Series     []heatmapSeries
+	TimeUnit   string
+}
+
+type heatmapSentence struct {
+	Confidence float64
+	Color      string
+	Words      []heatmapWord
+}
+
+type heatmapWord struct {
+	Word     string
+	Positive float64
+	Color    string
+}
+
+type heatmapSeries struct {
+	TimeSeries
+	Color string
+}
+
+// WantsHTML returns whether the request
+// prefers an HTML response, going by the
+// first media range in its Accept header
+func WantsHTML(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		return mediaType == "text/html"
+	}
+
+	return false
+}
+
+// RenderHeatmap writes an HTML page showing
+// the analyzed text with each sentence and
+// word colored by its sentiment. Series may
+// be nil for documents without time series
+// data. The series' times are labelled with
+// timeUnit, unless it's blank because they
+// were kept as the upstream gave them.
+func RenderHeatmap(w io.Writer, a *AnalysisResponse, text string, lex Lexicon, series []TimeSeries, timeUnit string) error {
+	h := heatmap{
+		Language:   string(a.Language),
+		Score:      a.Score,
+		Confidence: Confidence(a.Words),
+		TimeUnit:   timeUnit,
+	}
+
+	sentences := []string{text}
+	if len(a.Sentences) != 0 {
+		sentences = make([]string, len(a.Sentences))
+		for i := range a.Sentences {
+			sentences[i] = a.Sentences[i].Sentence
+		}
+	}
+
+	for _, sentence := range sentences {
+		if strings.TrimSpace(sentence) == "" {
+			continue
+		}
+
+		words := Analyze(strings.TrimSpace(sentence), a.Language, lex).Words
+		s := heatmapSentence{
+			Confidence: Confidence(words),
+		}
+		s.Color = heatColor(s.Confidence)
+
+		for _, word := range words {
+			if strings.TrimSpace(word.Word) == "" {
+				continue
+			}
+
+			p := positiveProbability(word)
+			s.Words = append(s.Words, heatmapWord{
+				Word:     word.Word,
+				Positive: p,
+				Color:    heatColor(p),
+			})
+		}
+
+		h.Sentences = append(h.Sentences, s)
+	}
+
+	for i := range series {
+		color := heatColor(0.25)
+		if series[i].Score == 1 {
+			color = heatColor(0.75)
+		}
+
+		h.Series = append(h.Series, heatmapSeries{
+			TimeSeries: series[i],
+			Color:      color,
+		})
+	}
+
+	return heatmapTemplate.Execute(w, h)
+}
+
+// heatColor maps the probability that something
+// is positive onto a hex color, going from red
+// (negative) through white to green (positive)
+func heatColor(p float64) string {
+	if p < 0 {
+		p = 0
+	}
+	if p > 1 {
+		p = 1
+	}
+
+	// probabilities cluster around 0.5 so the
+	// scale is stretched to make them visible
+	t := (p - 0.5) * 4
+	if t < -1 {
+		t = -1
+	}
+	if t > 1 {
+		t = 1
+	}
+
+	from, to := [3]float64{255, 255, 255}, [3]float64{79, 174, 90}
+	if t < 0 {
+		to = [3]float64{224, 88, 79}
+		t = -t
+	}
+
+	var rgb [3]uint8
+	for i := range rgb {
+		rgb[i] = uint8(from[i] + (to[i]-from[i])*t)
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
+
+// ServeHeatmap renders the heatmap for an
+// analysis and writes it as the response,
+// replacing the JSON Content-Type header. If
+// rendering fails nothing is written so the
+// caller can still respond with an error.
+func ServeHeatmap(r http.ResponseWriter, a *AnalysisResponse, text string, lex Lexicon, series []TimeSeries, timeUnit string) error {
+	var page bytes.Buffer
+	err := RenderHeatmap(&page, a, text, lex, series, timeUnit)
+	if err != nil {
+		return err
+	}
+
+	r.Header().Set("Content-Type", "text/html; charset=utf-8")
+	r.WriteHeader(http.StatusOK)
+	r.Write(page.Bytes())
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Highlights holds the most positive and
+// most negative sentences within a document,
+// most confident first. Only sentences
+// estimated to be positive are listed in
+// Positive (and likewise for Negative,) so
+// either can hold fewer items than were
+// requested.
+type Highlights struct {
+	Positive []Highlight `json:"positive"`
+	Negative []Highlight `json:"negative"`
+}
+
+// Highlight is a single sentence within
+// a document. Index is the position of
+// the sentence within the analysis'
+// Sentences array, and Start and End are
+// the byte offsets of the sentence within
+// the analyzed text (both -1 if the sentence
+// couldn't be located in the text.)
+type Highlight struct {
+	Sentence   string  `json:"sentence"`
+	Index      int     `json:"index"`
+	Start      int     `json:"start"`
+	End        int     `json:"end"`
+	Confidence float64 `json:"confidence"`
+}
+
+// AddHighlights finds the n most positive and n
+// most negative sentences within an analysis of
+// the given text and stores them within the
+// analysis. If the analysis has no sentences
+// (the text was only one sentence) the whole
+// text is treated as the single sentence.
+func AddHighlights(a *AnalysisResponse, text string, lex Lexicon, n int) {
+	if n < 1 {
+		return
+	}
+
+	sentences := []string{text}
+	if len(a.Sentences) != 0 {
+		sentences = make([]string, len(a.Sentences))
+		for i := range a.Sentences {
+			sentences[i] = a.Sentences[i].Sentence
+		}
+	}
+
+	spans := SentenceSpans(text, sentences)
+	positive := []Highlight{}
+	negative := []Highlight{}
+	for i, sentence := range sentences {
+		if strings.TrimSpace(sentence) == "" {
+			continue
+		}
+
+		h := Highlight{
+			Sentence:   strings.TrimSpace(sentence),
+			Index:      i,
+			Start:      spans[i][0],
+			End:        spans[i][1],
+			Confidence: Confidence(Analyze(sentence, a.Language, lex).Words),
+		}
+
+		if h.Confidence > 0.5 {
+			positive = append(positive, h)
+		} else if h.Confidence < 0.5 {
+			negative = append(negative, h)
+		}
+	}
+
+	sort.SliceStable(positive, func(i, j int) bool {
+		return positive[i].Confidence > positive[j].Confidence
+	})
+	sort.SliceStable(negative, func(i, j int) bool {
+		return negative[i].Confidence < negative[j].Confidence
+	})
+
+	if len(positive) > n {
+		positive = positive[:n]
+	}
+	if len(negative) > n {
+		negative = negative[:n]
+	}
+
+	a.Highlights = &Highlights{
+		Positive: positive,
+		Negative: negative,
+	}
+}
+
+// SentenceSpans locates each sentence within the
+// text, in order, returning the [start, end) byte
+// offsets of each. Sentences which can't be found
+// are given offsets of -1.
+func SentenceSpans(text string, sentences []string) [][2]int {
+	spans := make([][2]int, len(sentences))
+	cursor := 0
+	for i, sentence := range sentences {
+		spans[i] = [2]int{-1, -1}
+
+		s := strings.TrimSpace(sentence)
+		if s == "" {
+			continue
+		}
+
+		idx := strings.Index(text[cursor:], s)
+		if idx < 0 {
+			continue
+		}
+
+		spans[i] = [2]int{cursor + idx, cursor + idx + len(s)}
+		cursor += idx + len(s)
+	}
+
+	return spans
+}
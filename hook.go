@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// HookTemplateData is what a hook's body
+// template is executed with. ID is the
+// record id given in the TaskJSON and
+// Params holds any extra params given
+// (after defaults are filled in, and
+// including the id as "id".)
+//
+// A body template can use the json function
+// to quote values as JSON strings:
+//
+//	{"query": "{ ticket(id: {{json .ID}}) { body } }"}
+type HookTemplateData struct {
+	ID     string
+	Params map[string]string
+}
+
+// urlPlaceholder matches named placeholders
+// within a hook URL, like {id} or {org}
+var urlPlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// HookParam declares the validation rules
+// for a param given to a hook. Pattern is a
+// regular expression the whole value must
+// match, Required makes it an error to not
+// give the param, and Default is used when
+// the param isn't given.
+type HookParam struct {
+	Pattern  string `json:"pattern,omitempty"`
+	Required bool   `json:"required,omitempty"`
+	Default  string `json:"default,omitempty"`
+	pattern  *regexp.Regexp
+}
+
+// hookTemplateFuncs are the functions
+// available within body templates
+var hookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Prepare validates a hook and compiles any
+// templates within it. It must be called on
+// every hook before it's used.
+func (h *Hook) Prepare() error {
+	err := h.Lexicon.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid lexicon: %v", err)
+	}
+
+	if h.Callback != nil {
+		err = h.Callback.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid callback: %v", err)
+		}
+	}
+
+	err = h.resolveSecrets()
+	if err != nil {
+		return err
+	}
+
+	for name, param := range h.Params {
+		param.pattern = nil
+		if param.Pattern != "" {
+			param.pattern, err = regexp.Compile("^(?:" + param.Pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("invalid pattern for param '%v': %v", name, err)
+			}
+		}
+		h.Params[name] = param
+	}
+
+	err = h.prepareFormat()
+	if err != nil {
+		return err
+	}
+
+	h.keyPath = nil
+	if h.Key != "" {
+		h.keyPath, err = h.compileKey()
+		if err != nil {
+			return fmt.Errorf("invalid key: %v", err)
+		}
+	}
+
+	if h.TimeFields == nil {
+		h.TimeFields = &TimeFields{}
+	}
+	err = h.TimeFields.compile(h.compilePath)
+	if err != nil {
+		return fmt.Errorf("invalid time fields: %v", err)
+	}
+
+	if h.Collection != nil {
+		if h.Time {
+			return fmt.Errorf("a hook can't be both a time series and a collection")
+		}
+
+		err = h.Collection.compile(h.compilePath)
+		if err != nil {
+			return fmt.Errorf("invalid collection: %v", err)
+		}
+	}
+
+	if h.Pagination != nil {
+		err = h.Pagination.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid pagination: %v", err)
+		}
+		if h.Pagination.Type == PageOffset && !h.countsItems() {
+			return fmt.Errorf("invalid pagination: offset pagination needs a key, collection or time series to count items by")
+		}
+	}
+
+	if h.Client == nil {
+		h.Client = &ClientConfig{}
+	}
+	*h.Client = h.Client.withDefaults()
+	var tlsConfig *tls.Config
+	if h.TLS != nil {
+		tlsConfig, err = h.TLS.Load()
+		if err != nil {
+			return fmt.Errorf("invalid tls: %v", err)
+		}
+	}
+	err = h.prepareOutbound()
+	if err != nil {
+		return fmt.Errorf("invalid outbound policy: %v", err)
+	}
+	h.client = h.Client.NewClient(tlsConfig, h.outbound)
+	h.checkRedirectOrigin()
+
+	if h.Signing != nil {
+		err = h.Signing.prepare(h)
+		if err != nil {
+			return fmt.Errorf("invalid signing: %v", err)
+		}
+	}
+
+	h.tokens = nil
+	if h.OAuth != nil {
+		oauth := OAuthConfig{
+			TokenURL:     h.interpolate(h.OAuth.TokenURL),
+			ClientID:     h.interpolate(h.OAuth.ClientID),
+			ClientSecret: h.interpolate(h.OAuth.ClientSecret),
+			Scopes:       h.OAuth.Scopes,
+		}
+		err = oauth.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid oauth: %v", err)
+		}
+
+		// the client credentials are sent to the
+		// token url, so it must pass the policy too
+		tokenURL, _ := url.Parse(oauth.TokenURL)
+		err = h.outbound.AllowsURL(tokenURL)
+		if err != nil {
+			return fmt.Errorf("invalid oauth: token url isn't allowed: %v", err)
+		}
+
+		h.tokens = &tokenSource{
+			config: oauth,
+			client: h.client,
+		}
+	}
+
+	if h.Retry == nil {
+		h.Retry = &RetryPolicy{}
+	}
+	*h.Retry = h.Retry.withDefaults()
+	err = h.Retry.validate()
+	if err != nil {
+		return fmt.Errorf("invalid retry: %v", err)
+	}
+
+	if h.Breaker != nil {
+		*h.Breaker = h.Breaker.withDefaults()
+	}
+
+	h.Method = strings.ToUpper(h.Method)
+	if h.Method == "" {
+		h.Method = "GET"
+	}
+
+	h.bodyTemplate = nil
+	if h.Body != "" {
+		h.bodyTemplate, err = template.New("body").Funcs(hookTemplateFuncs).Option("missingkey=error").Parse(h.Body)
+		if err != nil {
+			return fmt.Errorf("invalid body template: %v", err)
+		}
+
+		if h.ContentType == "" {
+			h.ContentType = "application/json"
+		}
+	}
+
+	return nil
+}
+
+// ResolveParams returns the params for a task,
+// filling in defaults and checking each against
+// the hook's declared rules. The record id is
+// included as the param "id".
+func (h *Hook) ResolveParams(j TaskJSON) (map[string]string, error) {
+	params := map[string]string{}
+	for name, value := range j.Params {
+		params[name] = value
+	}
+	if j.ID != "" {
+		params["id"] = j.ID
+	}
+
+	for name, param := range h.Params {
+		value, ok := params[name]
+		if !ok && param.Default != "" {
+			value, ok = param.Default, true
+			params[name] = value
+		}
+
+		if !ok {
+			if param.Required {
+				return nil, fmt.Errorf("param '%v' is required", name)
+			}
+			continue
+		}
+
+		if param.pattern != nil && !param.pattern.MatchString(value) {
+			return nil, fmt.Errorf("param '%v' does not match the pattern '%v'", name, param.Pattern)
+		}
+	}
+
+	return params, nil
+}
+
+// BuildURL formats the params into the hook's
+// URL. Named placeholders ({org}) are replaced
+// with the param of the same name, escaped for
+// whichever part of the URL they're in, so values
+// can't add path segments or query params. URLs
+// without named placeholders are formatted with
+// fmt.Sprintf and the escaped id for backwards
+// compatibility. Secret references are
+// interpolated last, so params can't be
+// used to reveal secrets.
+func (h *Hook) BuildURL(params map[string]string) (string, error) {
+	query := strings.IndexAny(h.URL, "?#")
+	escape := func(at int, value string) string {
+		if query >= 0 && at > query {
+			return url.QueryEscape(value)
+		}
+
+		// dot segments would otherwise be
+		// resolved by the upstream
+		if value == "." || value == ".." {
+			return strings.Repeat("%2E", len(value))
+		}
+		return url.PathEscape(value)
+	}
+
+	if !urlPlaceholder.MatchString(h.URL) {
+		if !strings.Contains(h.URL, "%") {
+			return h.interpolate(h.URL), nil
+		}
+		return h.interpolate(fmt.Sprintf(h.URL, escape(strings.Index(h.URL, "%"), params["id"]))), nil
+	}
+
+	var (
+		formatted bytes.Buffer
+		missing   []string
+		last      int
+	)
+	for _, match := range urlPlaceholder.FindAllStringSubmatchIndex(h.URL, -1) {
+		name := h.URL[match[2]:match[3]]
+		value, ok := params[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		formatted.WriteString(h.URL[last:match[0]])
+		formatted.WriteString(escape(match[0], value))
+		last = match[1]
+	}
+	formatted.WriteString(h.URL[last:])
+
+	if len(missing) != 0 {
+		return "", fmt.Errorf("no value given for URL params %v", missing)
+	}
+
+	return h.interpolate(formatted.String()), nil
+}
+
+// NewRequest builds the upstream request for
+// a task with the hook's URL, method, headers,
+// and templated body
+func (h *Hook) NewRequest(j TaskJSON) (*http.Request, error) {
+	params, err := h.ResolveParams(j)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := h.BuildURL(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if h.bodyTemplate != nil {
+		err := h.bodyTemplate.Execute(&body, HookTemplateData{
+			ID:     j.ID,
+			Params: params,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	method := h.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	req, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range h.Headers {
+		for _, value := range values {
+			req.Header.Add(key, h.interpolate(value))
+		}
+	}
+	if h.bodyTemplate != nil {
+		req.Header.Set("Content-Type", h.ContentType)
+	}
+
+	return req, nil
+}
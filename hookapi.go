@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+var (
+	// hooksLock serializes changes to the hooks
+	hooksLock sync.Mutex
+
+	// hooks holds the current hookSet. Sets are
+	// never changed once stored, so readers get
+	// a consistent view of the hooks without
+	// locking, and tasks keep using the hook
+	// definition they started with.
+	hooks atomic.Value
+
+	// hookID is what hook ids can look like
+	hookID = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// hookSet is the prepared hooks along with
+// the definitions they were given as
+type hookSet struct {
+	hooks       map[string]Hook
+	definitions map[string]json.RawMessage
+}
+
+// HookDefinitionJSON is the JSON given
+// for a hook by the hook management API
+type HookDefinitionJSON struct {
+	ID   string          `json:"id"`
+	Hook json.RawMessage `json:"hook"`
+}
+
+// CurrentHooks returns the current hooks,
+// including any changes made through the hook
+// management API. The map must not be changed.
+func CurrentHooks() map[string]Hook {
+	set, _ := hooks.Load().(hookSet)
+	return set.hooks
+}
+
+// currentDefinitions returns the definitions
+// of the current hooks. The map must not be
+// changed.
+func currentDefinitions() map[string]json.RawMessage {
+	set, _ := hooks.Load().(hookSet)
+	return set.definitions
+}
+
+// LoadHooks prepares the config's hooks (or the
+// hooks saved by the hook management API, if
+// there are any) and makes them current. The
+// default hook must be one of them, and is
+// picked from them when the config doesn't
+// give one.
+func LoadHooks() error {
+	definitions := map[string]json.RawMessage{}
+	for id, hook := range Config.Hooks {
+		data, err := json.Marshal(hook)
+		if err != nil {
+			return fmt.Errorf("ERROR: invalid hook '%v': %v", id, err)
+		}
+		definitions[id] = data
+	}
+
+	if Config.Admin != nil && Config.Admin.HooksFile != "" {
+		data, err := ioutil.ReadFile(Config.Admin.HooksFile)
+		if err == nil {
+			definitions = map[string]json.RawMessage{}
+			err = json.Unmarshal(data, &definitions)
+			if err != nil {
+				return fmt.Errorf("ERROR: error unmarshalling hooks file %v: %v", Config.Admin.HooksFile, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("ERROR: error reading hooks file %v: %v", Config.Admin.HooksFile, err)
+		}
+	}
+
+	set := hookSet{
+		hooks:       map[string]Hook{},
+		definitions: definitions,
+	}
+	for id, definition := range definitions {
+		hook, err := parseHook(definition)
+		if err != nil {
+			return fmt.Errorf("ERROR: invalid hook '%v': %v", id, err)
+		}
+		set.hooks[id] = hook
+	}
+
+	if Config.DefaultHook == "" {
+		for id := range set.hooks {
+			Config.DefaultHook = id
+		}
+	}
+	if _, ok := set.hooks[Config.DefaultHook]; !ok && Config.DefaultHook != "" {
+		return fmt.Errorf("ERROR: the default hook '%v' isn't one of the hooks", Config.DefaultHook)
+	}
+
+	Config.Hooks = set.hooks
+	hooks.Store(set)
+	return nil
+}
+
+// ReloadHooks prepares the current hooks again
+// from their definitions, so their secrets are
+// resolved again and rotated secret files are
+// picked up. Changes made through the hook
+// management API are kept. If any hook fails
+// the current hooks are kept as they are.
+func ReloadHooks() error {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	current, _ := hooks.Load().(hookSet)
+	next := hookSet{
+		hooks:       map[string]Hook{},
+		definitions: current.definitions,
+	}
+	for id, definition := range current.definitions {
+		hook, err := parseHook(definition)
+		if err != nil {
+			return fmt.Errorf("ERROR: invalid hook '%v': %v", id, err)
+		}
+		next.hooks[id] = hook
+	}
+
+	hooks.Store(next)
+	return nil
+}
+
+// reloadHooksOnHangup reloads the hooks
+// whenever the server is sent a SIGHUP
+func reloadHooksOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		err := ReloadHooks()
+		if err != nil {
+			log.Printf("SIGHUP > ERROR: error reloading hooks, keeping the current ones\n\t%v\n", err)
+			continue
+		}
+		log.Printf("SIGHUP > reloaded hooks\n")
+	}
+}
+
+// parseHook unmarshals and prepares a hook
+func parseHook(definition json.RawMessage) (Hook, error) {
+	hook := Hook{}
+	err := json.Unmarshal(definition, &hook)
+	if err != nil {
+		return Hook{}, err
+	}
+
+	err = hook.Prepare()
+	if err != nil {
+		return Hook{}, err
+	}
+
+	return hook, nil
+}
+
+// parseAPIHook is parseHook for hooks given
+// through the API, which can only reference
+// the secrets the admin config allows
+func parseAPIHook(definition json.RawMessage) (Hook, error) {
+	hook := Hook{}
+	err := json.Unmarshal(definition, &hook)
+	if err != nil {
+		return Hook{}, err
+	}
+
+	err = hook.checkAPISecrets()
+	if err != nil {
+		return Hook{}, err
+	}
+
+	return parseHook(definition)
+}
+
+// changeHook creates, replaces, or (when the
+// definition is nil) deletes a hook. The change
+// is saved and then applied all at once. It
+// returns the status to respond with.
+func changeHook(method, id string, definition json.RawMessage) (int, error) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	current, _ := hooks.Load().(hookSet)
+	_, exists := current.hooks[id]
+
+	switch {
+	case method == "POST" && exists:
+		return http.StatusConflict, fmt.Errorf("hook '%v' already exists", id)
+	case method != "POST" && !exists:
+		return http.StatusNotFound, fmt.Errorf("hook '%v' does not exist", id)
+	}
+
+	next := hookSet{
+		hooks:       map[string]Hook{},
+		definitions: map[string]json.RawMessage{},
+	}
+	for other, hook := range current.hooks {
+		if other != id {
+			next.hooks[other] = hook
+			next.definitions[other] = current.definitions[other]
+		}
+	}
+
+	if definition != nil {
+		hook, err := parseAPIHook(definition)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid hook: %v", err)
+		}
+
+		next.hooks[id] = hook
+		next.definitions[id] = definition
+	}
+
+	if _, ok := next.hooks[Config.DefaultHook]; !ok && Config.DefaultHook != "" {
+		return http.StatusConflict, fmt.Errorf("hook '%v' is the default hook and can't be deleted", Config.DefaultHook)
+	}
+	if definition == nil {
+		for name, schedule := range Config.Schedules {
+			if schedule.HookID == id {
+				return http.StatusConflict, fmt.Errorf("hook '%v' is used by schedule '%v' and can't be deleted", id, name)
+			}
+		}
+	}
+
+	err := saveHooks(next.definitions)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("couldn't save hooks: %v", err)
+	}
+
+	hooks.Store(next)
+	if cache != nil {
+		cache.Purge(FetchCache + "/" + id + "/")
+	}
+
+	return http.StatusOK, nil
+}
+
+// saveHooks saves the hook definitions to the
+// hooks file. Without one the hooks are only
+// kept in memory, since rewriting the config
+// file would lose its formatting.
+func saveHooks(definitions map[string]json.RawMessage) error {
+	if Config.Admin.HooksFile == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(definitions, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(Config.Admin.HooksFile, data)
+}
+
+// writeFileAtomic writes a file through a
+// temporary file so readers never see a
+// partially written one
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// HandleListHooks gives the
+// definitions of every hook
+func HandleListHooks(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	data, err := json.Marshal(struct {
+		DefaultHook string                     `json:"defaultHook"`
+		Hooks       map[string]json.RawMessage `json:"hooks"`
+	}{
+		DefaultHook: Config.DefaultHook,
+		Hooks:       currentDefinitions(),
+	})
+	if err != nil {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error marshalling hooks", "error": "%v"}`, err.Error())))
+		log.Printf("GET /hooks > ERROR: error marshalling hooks\n\t%v\n", err)
+		return
+	}
+
+	r.WriteHeader(http.StatusOK)
+	r.Write(data)
+}
+
+// HandleHook gets (GET), creates (POST),
+// replaces (PUT) or deletes (DELETE) the
+// hook at /hooks/{id}
+func HandleHook(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	id := strings.TrimPrefix(req.URL.Path, "/hooks/")
+	if !hookID.MatchString(id) {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: hook ids must only hold letters, digits, '_', '-' and '.'", "hookId": "%v"}`, id)))
+		log.Printf("%v /hooks/%v > ERROR: invalid hook id\n", req.Method, id)
+		return
+	}
+
+	if req.Method == "GET" {
+		definition, ok := currentDefinitions()[id]
+		if !ok {
+			r.WriteHeader(http.StatusNotFound)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: hook given was not found in your configured hooks!", "hookId": "%v"}`, id)))
+			return
+		}
+
+		data, _ := json.Marshal(HookDefinitionJSON{
+			ID:   id,
+			Hook: definition,
+		})
+		r.WriteHeader(http.StatusOK)
+		r.Write(data)
+		return
+	}
+
+	var definition json.RawMessage
+	if req.Method != "DELETE" {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			r.WriteHeader(http.StatusInternalServerError)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error reading request body", "error": "%v"}`, err.Error())))
+			log.Printf("%v /hooks/%v > ERROR: couldn't read request body\n\t%v\n", req.Method, id, err)
+			return
+		}
+
+		var compacted bytes.Buffer
+		err = json.Compact(&compacted, data)
+		if err != nil || !bytes.HasPrefix(compacted.Bytes(), []byte("{")) {
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(`{"message": "ERROR: the hook must be given as a JSON object"}`))
+			log.Printf("%v /hooks/%v > ERROR: hook given wasn't a JSON object\n", req.Method, id)
+			return
+		}
+		definition = compacted.Bytes()
+	}
+
+	status, err := changeHook(req.Method, id, definition)
+	if err != nil {
+		message, _ := json.Marshal(err.Error())
+		r.WriteHeader(status)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to change hook", "hookId": "%v", "error": %s}`, id, message)))
+		log.Printf("%v /hooks/%v > ERROR: unable to change hook\n\t%v\n", req.Method, id, err)
+		return
+	}
+
+	log.Printf("%v /hooks/%v > hook changed\n", req.Method, id)
+
+	if definition == nil {
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(fmt.Sprintf(`{"hookId": "%v", "deleted": true}`, id)))
+		return
+	}
+
+	if req.Method == "POST" {
+		r.Header().Add("Location", "/hooks/"+id)
+		status = http.StatusCreated
+	}
+
+	data, _ := json.Marshal(HookDefinitionJSON{
+		ID:   id,
+		Hook: definition,
+	})
+	r.WriteHeader(status)
+	r.Write(data)
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMaxResponseSize caps hook response
+	// bodies at 10MB unless the hook says otherwise
+	defaultMaxResponseSize = 10 << 20
+
+	// maxExcerptSize caps the body kept from
+	// failed responses and shown by dry runs
+	maxExcerptSize = 4096
+)
+
+// ClientConfig configures the HTTP client used
+// for a hook's requests. Every field is optional:
+//
+//   - ConnectTimeout caps establishing the
+//     connection (defaults to 10s)
+//   - Timeout caps the whole request, including
+//     reading the body (defaults to 30s)
+//   - MaxResponseSize is the largest body, in
+//     bytes, that will be read (defaults to 10MB)
+//   - AcceptedStatus lists the upstream status
+//     codes which are analyzed (defaults to any 2xx)
+//   - MaxRedirects is the number of redirects
+//     followed, where a negative number follows
+//     none (defaults to 10)
+//   - MaxIdleConns, MaxIdleConnsPerHost, and
+//     IdleConnTimeout size the keep-alive pool
+//     (default to 100, 2, and 90s)
+type ClientConfig struct {
+	ConnectTimeout  Duration `json:"connectTimeout,omitempty"`
+	Timeout         Duration `json:"timeout,omitempty"`
+	MaxResponseSize int64    `json:"maxResponseSize,omitempty"`
+	AcceptedStatus  []int    `json:"acceptedStatus,omitempty"`
+	MaxRedirects    int      `json:"maxRedirects,omitempty"`
+
+	MaxIdleConns        int      `json:"maxIdleConns,omitempty"`
+	MaxIdleConnsPerHost int      `json:"maxIdleConnsPerHost,omitempty"`
+	IdleConnTimeout     Duration `json:"idleConnTimeout,omitempty"`
+}
+
+// HookError is returned when a hook's upstream
+// fails: the request errors or times out, or the
+// response has an unaccepted status or is too
+// large. Status is the status the server should
+// respond with (502 or 504, 503 when the hook's
+// circuit breaker is open, or 403 when the hook's
+// outbound policy blocks the request) and
+// UpstreamStatus is the status the upstream gave,
+// if any.
+type HookError struct {
+	Status         int    `json:"-"`
+	Message        string `json:"message"`
+	Hook           string `json:"hook"`
+	UpstreamStatus int    `json:"upstreamStatus,omitempty"`
+	Err            string `json:"error,omitempty"`
+
+	Breaker *BreakerState `json:"breaker,omitempty"`
+	Blocked bool          `json:"blocked,omitempty"`
+
+	// response holds what the upstream responded
+	// with, if anything, for dry runs
+	response *UpstreamResponse
+
+	// transport is set when the request couldn't
+	// be completed, so it's worth retrying
+	transport bool
+}
+
+// Error returns the error as a JSON object,
+// like the rest of the hook errors
+func (e *HookError) Error() string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+// ErrorStatus returns the status code the
+// server should respond with for an error
+// from running a hook
+func ErrorStatus(err error) int {
+	var hookErr *HookError
+	if errors.As(err, &hookErr) {
+		return hookErr.Status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// withDefaults fills in the default
+// client settings
+func (c ClientConfig) withDefaults() ClientConfig {
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = Duration(10 * time.Second)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = Duration(30 * time.Second)
+	}
+	if c.MaxResponseSize <= 0 {
+		c.MaxResponseSize = defaultMaxResponseSize
+	}
+	if c.MaxRedirects == 0 {
+		c.MaxRedirects = 10
+	}
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = 100
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = http.DefaultMaxIdleConnsPerHost
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = Duration(90 * time.Second)
+	}
+
+	return c
+}
+
+// NewClient builds the HTTP client for the
+// configuration, using the given TLS config
+// (if any) for HTTPS upstreams. Connections
+// and redirects are checked against the
+// outbound policy, if one is given.
+//
+// Clients with a policy never use a proxy
+// from the environment, since the dialer would
+// only see the proxy's IP and not the IP of
+// the upstream it's checking.
+func (c ClientConfig) NewClient(tlsConfig *tls.Config, policy *OutboundPolicy) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(c.ConnectTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	proxy := http.ProxyFromEnvironment
+	if policy != nil {
+		dialer.Control = policy.control
+		proxy = nil
+	}
+
+	transport := &http.Transport{
+		Proxy:               proxy,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Duration(c.IdleConnTimeout),
+		TLSHandshakeTimeout: time.Duration(c.ConnectTimeout),
+		TLSClientConfig:     tlsConfig,
+	}
+
+	maxRedirects := c.MaxRedirects
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(c.Timeout),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if maxRedirects < 0 {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %v redirects", maxRedirects)
+			}
+			if policy != nil {
+				return policy.AllowsURL(req.URL)
+			}
+			return nil
+		},
+	}
+}
+
+// accepts returns whether the upstream
+// status is one the hook analyzes
+func (c ClientConfig) accepts(status int) bool {
+	if len(c.AcceptedStatus) == 0 {
+		return status >= 200 && status <= 299
+	}
+
+	for _, accepted := range c.AcceptedStatus {
+		if status == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// UpstreamResponse is the response to a
+// hook request
+type UpstreamResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// NotModified returns whether the upstream
+// answered a conditional request by saying
+// the cached response is still good
+func (u *UpstreamResponse) NotModified() bool {
+	return u.Status == http.StatusNotModified
+}
+
+// Do performs a hook request, returning the
+// upstream's response. The request is retried as the
+// hook's retry policy allows, and fails fast if
+// the hook's circuit breaker is open or the
+// request is blocked by the hook's outbound
+// policy. Upstream failures are given as a
+// *HookError.
+func (h *Hook) Do(id string, req *http.Request) (*UpstreamResponse, error) {
+	err := h.allows(id, req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	breaker := hookBreaker(id, h)
+	if breaker != nil && !breaker.Allow() {
+		state := breaker.State()
+		return nil, &HookError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "ERROR: HOOK circuit breaker is open after repeated upstream failures",
+			Hook:    id,
+			Breaker: &state,
+		}
+	}
+
+	var resp *UpstreamResponse
+	for attempt := 1; attempt <= h.Retry.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(h.Retry.wait(attempt - 1))
+
+			req, err = rewind(req)
+			if err != nil {
+				break
+			}
+		}
+
+		resp, err = h.send(id, req)
+		if err == nil || !h.Retry.retries(req.Method, err) {
+			break
+		}
+	}
+
+	if breaker != nil {
+		breaker.Record(upstreamFailed(err))
+	}
+
+	return resp, err
+}
+
+// rewind returns a copy of a request with
+// its body reset so it can be resent
+func rewind(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	return retry, nil
+}
+
+// send makes an attempt at a hook request,
+// authorizing and signing it first. When the upstream
+// rejects the hook's OAuth2 token the request
+// is retried once with a fresh token.
+func (h *Hook) send(id string, req *http.Request) (*UpstreamResponse, error) {
+	token, err := h.authorize(id, req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.sign(id, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.do(id, req)
+	if token == "" || !unauthorized(err) {
+		return resp, err
+	}
+
+	h.tokens.Invalidate(token)
+	req, err = rewind(req)
+	if err != nil {
+		return nil, err
+	}
+	_, err = h.authorize(id, req)
+	if err != nil {
+		return nil, err
+	}
+	err = h.sign(id, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.do(id, req)
+}
+
+// sign signs a request when the
+// hook has a signing config
+func (h *Hook) sign(id string, req *http.Request) error {
+	if h.Signing == nil {
+		return nil
+	}
+
+	err := h.Signing.Sign(req)
+	if err != nil {
+		return &HookError{
+			Status:  http.StatusBadGateway,
+			Message: "ERROR: could not sign HOOK request",
+			Hook:    id,
+			Err:     err.Error(),
+		}
+	}
+
+	return nil
+}
+
+// do makes a single attempt at a hook request.
+// A 304 is accepted for conditional requests.
+func (h *Hook) do(id string, req *http.Request) (*UpstreamResponse, error) {
+	resp, err := h.client.Do(req)
+	if err != nil && outboundBlocked(err) {
+		return nil, &HookError{
+			Status:  http.StatusForbidden,
+			Message: "ERROR: HOOK request blocked by outbound policy",
+			Hook:    id,
+			Err:     h.redact(err.Error()),
+			Blocked: true,
+		}
+	}
+	if err != nil {
+		status := http.StatusBadGateway
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			status = http.StatusGatewayTimeout
+		}
+
+		return nil, &HookError{
+			Status:    status,
+			Message:   "ERROR: could not complete HOOK request",
+			Hook:      id,
+			Err:       h.redact(err.Error()),
+			transport: true,
+		}
+	}
+	defer resp.Body.Close()
+
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return &UpstreamResponse{
+			Status: resp.StatusCode,
+			Header: resp.Header,
+		}, nil
+	}
+
+	if !h.Client.accepts(resp.StatusCode) {
+		// read a little so the connection can be
+		// reused, keeping it for dry runs
+		excerpt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxExcerptSize))
+
+		return nil, &HookError{
+			Status:         http.StatusBadGateway,
+			Message:        "ERROR: HOOK request responded with an unaccepted status",
+			Hook:           id,
+			UpstreamStatus: resp.StatusCode,
+			response: &UpstreamResponse{
+				Status: resp.StatusCode,
+				Header: resp.Header,
+				Body:   excerpt,
+			},
+		}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, h.Client.MaxResponseSize+1))
+	if err != nil {
+		status := http.StatusBadGateway
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			status = http.StatusGatewayTimeout
+		}
+
+		return nil, &HookError{
+			Status:         status,
+			Message:        "ERROR: could not read the body from HOOK request",
+			Hook:           id,
+			UpstreamStatus: resp.StatusCode,
+			Err:            h.redact(err.Error()),
+		}
+	}
+
+	if int64(len(data)) > h.Client.MaxResponseSize {
+		return nil, &HookError{
+			Status:         http.StatusBadGateway,
+			Message:        fmt.Sprintf("ERROR: HOOK response body is larger than the %v byte limit", h.Client.MaxResponseSize),
+			Hook:           id,
+			UpstreamStatus: resp.StatusCode,
+			response: &UpstreamResponse{
+				Status: resp.StatusCode,
+				Header: resp.Header,
+				Body:   data,
+			},
+		}
+	}
+
+	return &UpstreamResponse{
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   data,
+	}, nil
+}
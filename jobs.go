@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// JobQueued means a job is waiting
+	// for a free worker
+	JobQueued = "queued"
+
+	// JobRunning means a worker is
+	// performing the job
+	JobRunning = "running"
+
+	// JobDone means the job succeeded
+	// and its result is available
+	JobDone = "done"
+
+	// JobFailed means the job errored
+	JobFailed = "failed"
+)
+
+var (
+	// jobs is the global queue of
+	// asynchronous tasks
+	jobs *JobQueue
+)
+
+// JobsConfig holds the configuration for
+// asynchronous POST /task jobs. Workers is
+// the number of jobs performed concurrently
+// (defaults to 4,) QueueSize is the number of
+// jobs which can wait for a worker before
+// new jobs are rejected (defaults to 100,)
+// and Retention is how long finished jobs
+// are kept for polling (defaults to 1h.)
+type JobsConfig struct {
+	Workers   int      `json:"workers,omitempty"`
+	QueueSize int      `json:"queueSize,omitempty"`
+	Retention Duration `json:"retention,omitempty"`
+}
+
+// Job is an asynchronous POST /task request.
+// Result holds the same JSON POST /task would
+// have responded with once the job is done,
+// and Error holds the error if it failed.
+type Job struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+
+	// Delivery records the attempts at
+	// POSTing the result to the job's
+	// callback, if it has one
+	Delivery *Delivery `json:"delivery,omitempty"`
+
+	task     TaskJSON
+	callback *Callback
+}
+
+// JobQueue runs jobs on a fixed number of
+// background workers and keeps finished
+// jobs around for the retention period
+type JobQueue struct {
+	sync.Mutex
+
+	jobs      map[string]*Job
+	queue     chan *Job
+	retention time.Duration
+	callbacks CallbacksConfig
+}
+
+// NewJobQueue creates a job queue and
+// starts its workers
+func NewJobQueue(c JobsConfig, callbacks CallbacksConfig) *JobQueue {
+	if c.Workers < 1 {
+		c.Workers = 4
+	}
+	if c.QueueSize < 1 {
+		c.QueueSize = 100
+	}
+	if c.Retention <= 0 {
+		c.Retention = Duration(time.Hour)
+	}
+
+	q := &JobQueue{
+		jobs:      map[string]*Job{},
+		queue:     make(chan *Job, c.QueueSize),
+		retention: time.Duration(c.Retention),
+		callbacks: callbacks.withDefaults(),
+	}
+
+	for i := 0; i < c.Workers; i++ {
+		go q.work()
+	}
+	go q.expire()
+
+	return q
+}
+
+// Submit queues a task to be run in the
+// background, returning a snapshot of the
+// queued job. Errors if the queue is full.
+//
+// The job's result is POSTed to the task's
+// callback, or the hook's default callback
+// if the task doesn't give one.
+func (q *JobQueue) Submit(j TaskJSON) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:       id,
+		Status:   JobQueued,
+		Created:  time.Now().UTC(),
+		task:     j,
+		callback: j.Callback,
+	}
+
+	if job.callback == nil {
+		if _, hook, err := FindHook(j.HookID); err == nil {
+			job.callback = hook.Callback
+		}
+	}
+	if job.callback != nil {
+		job.Delivery = &Delivery{
+			URL:      job.callback.URL,
+			Status:   DeliveryPending,
+			Attempts: []DeliveryAttempt{},
+		}
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	select {
+	case q.queue <- job:
+	default:
+		return Job{}, fmt.Errorf("job queue is full (%v jobs waiting)", cap(q.queue))
+	}
+
+	q.jobs[id] = job
+	return *job, nil
+}
+
+// Get returns a snapshot of the job with
+// the given id
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	snapshot := *job
+	if job.Delivery != nil {
+		delivery := *job.Delivery
+		delivery.Attempts = append([]DeliveryAttempt{}, job.Delivery.Attempts...)
+		snapshot.Delivery = &delivery
+	}
+
+	return snapshot, true
+}
+
+// work performs jobs off of the queue
+// forever
+func (q *JobQueue) work() {
+	for job := range q.queue {
+		now := time.Now().UTC()
+
+		q.Lock()
+		job.Status = JobRunning
+		job.Started = &now
+		q.Unlock()
+
+		status, result, failure := JobDone, json.RawMessage(nil), json.RawMessage(nil)
+
+		r, err := RunTask(job.task)
+		if err == nil {
+			result, err = json.Marshal(r.Response())
+		}
+		if err != nil {
+			status = JobFailed
+			failure = errorJSON(err)
+			log.Printf("JOB %v > ERROR: error running task\n\t%v\n", job.ID, err)
+		} else {
+			atomic.AddInt64(&hookCount, 1)
+			atomic.AddInt64(&count, 1)
+			log.Printf("JOB %v [len(text) = %v]\n", job.ID, len(r.Text))
+		}
+
+		finished := time.Now().UTC()
+
+		q.Lock()
+		job.Status = status
+		job.Result = result
+		job.Error = failure
+		job.Finished = &finished
+		q.Unlock()
+
+		if job.callback != nil {
+			body := result
+			if status == JobFailed {
+				body = failure
+			}
+			go q.deliver(job, body)
+		}
+	}
+}
+
+// expire removes finished jobs once they
+// are older than the retention period
+func (q *JobQueue) expire() {
+	interval := q.retention / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	for range time.Tick(interval) {
+		cutoff := time.Now().Add(-q.retention)
+
+		q.Lock()
+		for id, job := range q.jobs {
+			if job.Delivery != nil && job.Delivery.Status == DeliveryPending {
+				continue
+			}
+			if job.Finished != nil && job.Finished.Before(cutoff) {
+				delete(q.jobs, id)
+			}
+		}
+		q.Unlock()
+	}
+}
+
+// HandleJobStatus responds with the status of
+// the job with the id given in the path (GET
+// /task/{id},) including its result when done
+func HandleJobStatus(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	id := strings.TrimPrefix(req.URL.Path, "/task/")
+	job, ok := jobs.Get(id)
+	if !ok {
+		r.WriteHeader(http.StatusNotFound)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: job not found. It may have expired", "id": "%v"}`, id)))
+		log.Printf("GET /task/%v > ERROR: job not found\n", id)
+		return
+	}
+
+	resp, err := json.Marshal(job)
+	if err != nil {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to marshal job into JSON", "error": "%v"}`, err.Error())))
+		log.Printf("GET /task/%v > ERROR: error marshalling job into JSON\n\t%v\n", id, err)
+		return
+	}
+
+	r.WriteHeader(http.StatusOK)
+	r.Write(resp)
+
+	log.Printf("GET /task/%v [status = %v]\n", id, job.Status)
+}
+
+// newJobID returns a random hex id
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// errorJSON returns an error as JSON. Most
+// errors in the server are already JSON
+// objects, but any others are quoted as
+// a string.
+func errorJSON(err error) json.RawMessage {
+	if json.Valid([]byte(err.Error())) {
+		return json.RawMessage(err.Error())
+	}
+
+	quoted, _ := json.Marshal(err.Error())
+	return json.RawMessage(quoted)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a compiled JSONPath-style
+// expression used to find values within
+// a decoded JSON document. It supports:
+//
+//	$            the root of the document
+//	.name        a field of an object
+//	['name']     a field (for names with dots, etc.)
+//	[2], [-1]    an array index (negative from the end)
+//	[*], .*      every array element or object value
+//	..name       the field at any depth
+//
+// For example $.data.comments[*].body finds
+// the body of every comment.
+type JSONPath struct {
+	expr  string
+	steps []pathStep
+}
+
+type pathStepKind int
+
+const (
+	stepField pathStepKind = iota
+	stepIndex
+	stepWildcard
+	stepDescend
+	stepText
+)
+
+type pathStep struct {
+	kind  pathStepKind
+	name  string
+	index int
+}
+
+// CompileJSONPath parses a JSONPath expression.
+// Expressions not starting with $ are treated
+// as the name of a single top level field, so
+// plain keys keep working.
+func CompileJSONPath(expr string) (*JSONPath, error) {
+	p := &JSONPath{expr: expr}
+	if !strings.HasPrefix(expr, "$") {
+		p.steps = []pathStep{{kind: stepField, name: expr}}
+		return p, nil
+	}
+
+	rest := expr[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			name, remaining := splitPathName(rest[2:])
+			if name == "" {
+				return nil, fmt.Errorf("expected a field name after '..' in '%v'", expr)
+			}
+			p.steps = append(p.steps, pathStep{kind: stepDescend, name: name})
+			rest = remaining
+
+		case rest[0] == '.':
+			name, remaining := splitPathName(rest[1:])
+			switch name {
+			case "":
+				return nil, fmt.Errorf("expected a field name after '.' in '%v'", expr)
+			case "*":
+				p.steps = append(p.steps, pathStep{kind: stepWildcard})
+			default:
+				p.steps = append(p.steps, pathStep{kind: stepField, name: name})
+			}
+			rest = remaining
+
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed '[' in '%v'", expr)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+
+			if inner == "*" {
+				p.steps = append(p.steps, pathStep{kind: stepWildcard})
+				continue
+			}
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p.steps = append(p.steps, pathStep{kind: stepField, name: inner[1 : len(inner)-1]})
+				continue
+			}
+
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index '[%v]' in '%v'", inner, expr)
+			}
+			p.steps = append(p.steps, pathStep{kind: stepIndex, index: index})
+
+		default:
+			return nil, fmt.Errorf("unexpected '%c' in '%v'", rest[0], expr)
+		}
+	}
+
+	return p, nil
+}
+
+// splitPathName splits a field name off
+// of the front of a path
+func splitPathName(s string) (string, string) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// String returns the expression the
+// path was compiled from
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Find returns every value within the document
+// (as decoded by encoding/json into an
+// interface{}) matched by the path, in
+// document order. Object fields matched by
+// wildcards are ordered by name.
+func (p *JSONPath) Find(doc interface{}) []interface{} {
+	current := []interface{}{doc}
+	for _, step := range p.steps {
+		next := []interface{}{}
+		for _, v := range current {
+			next = append(next, step.apply(v)...)
+		}
+		current = next
+	}
+
+	return current
+}
+
+// apply returns the values matched by
+// a single step from v
+func (s pathStep) apply(v interface{}) []interface{} {
+	switch s.kind {
+	case stepField:
+		if obj, ok := v.(map[string]interface{}); ok {
+			if field, ok := obj[s.name]; ok {
+				return []interface{}{field}
+			}
+		}
+
+	case stepIndex:
+		if arr, ok := v.([]interface{}); ok {
+			i := s.index
+			if i < 0 {
+				i += len(arr)
+			}
+			if i >= 0 && i < len(arr) {
+				return []interface{}{arr[i]}
+			}
+		}
+
+	case stepWildcard:
+		return children(v)
+
+	case stepText:
+		switch t := v.(type) {
+		case string:
+			return []interface{}{t}
+		case map[string]interface{}:
+			if text, ok := t[xmlText]; ok {
+				return []interface{}{text}
+			}
+		}
+
+	case stepDescend:
+		found := []interface{}{}
+		if obj, ok := v.(map[string]interface{}); ok {
+			if field, ok := obj[s.name]; ok {
+				found = append(found, field)
+			}
+		}
+		for _, child := range children(v) {
+			found = append(found, s.apply(child)...)
+		}
+		return found
+	}
+
+	return nil
+}
+
+// children returns the elements of an array
+// or the values of an object (ordered by
+// field name)
+func children(v interface{}) []interface{} {
+	switch t := v.(type) {
+	case []interface{}:
+		return t
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i] = t[key]
+		}
+		return values
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Lexicon holds word-level overrides for the
+// sentiment model, keyed by the lowercased
+// word (without surrounding punctuation.)
+// This lets a consumer flip or nudge words
+// which mean something different in their
+// domain than they do in movie reviews:
+//
+//	{
+//	  "sick": {"probability": 0.9},
+//	  "killer": {"bias": 0.3}
+//	}
+type Lexicon map[string]LexiconEntry
+
+// LexiconEntry is a single word override.
+// If Probability is given it replaces the
+// model's probability that the word is
+// positive outright. Otherwise Bias is
+// added to the model's probability (and
+// clamped onto [0,1].)
+type LexiconEntry struct {
+	Probability *float64 `json:"probability,omitempty"`
+	Bias        float64  `json:"bias,omitempty"`
+}
+
+// OverriddenWord flags a word within an
+// analysis which had its probability changed
+// by a Lexicon. Index is the position of the
+// word within the analysis' Words array.
+type OverriddenWord struct {
+	Index    int     `json:"index"`
+	Word     string  `json:"word"`
+	Original float64 `json:"original"`
+	Positive float64 `json:"positive"`
+}
+
+// Validate makes sure all the entries in the
+// lexicon hold sane probabilities
+func (l Lexicon) Validate() error {
+	for word, entry := range l {
+		if entry.Probability != nil && (*entry.Probability < 0 || *entry.Probability > 1) {
+			return fmt.Errorf("lexicon probability for word '%v' must be on [0,1], given %v", word, *entry.Probability)
+		}
+		if entry.Bias < -1 || entry.Bias > 1 {
+			return fmt.Errorf("lexicon bias for word '%v' must be on [-1,1], given %v", word, entry.Bias)
+		}
+	}
+
+	return nil
+}
+
+// MergeLexicons returns a new Lexicon with the
+// entries of every given lexicon, where later
+// lexicons take precedence over earlier ones.
+// Returns nil if there are no entries at all.
+func MergeLexicons(lexicons ...Lexicon) Lexicon {
+	var merged Lexicon
+	for _, l := range lexicons {
+		for word, entry := range l {
+			if merged == nil {
+				merged = Lexicon{}
+			}
+			merged[NormalizeWord(word)] = entry
+		}
+	}
+
+	return merged
+}
+
+// NormalizeWord lowercases a word and strips
+// any leading or trailing punctuation so that
+// "Sick!" and "sick" share a lexicon entry
+func NormalizeWord(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// Lookup returns the overridden probability that
+// the given word is positive, given the model's
+// probability that it's positive. The bool is
+// false if the lexicon has no entry for the word.
+func (l Lexicon) Lookup(word string, positive float64) (float64, bool) {
+	if len(l) == 0 {
+		return positive, false
+	}
+
+	entry, ok := l[NormalizeWord(word)]
+	if !ok {
+		return positive, false
+	}
+
+	if entry.Probability != nil {
+		return *entry.Probability, true
+	}
+
+	p := positive + entry.Bias
+	if p < 0 {
+		p = 0
+	}
+	if p > 1 {
+		p = 1
+	}
+
+	return p, true
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"net/http"
+	"text/template"
+
 	"github.com/cdipaolo/sentiment"
 )
 
@@ -10,8 +13,8 @@ import (
 // the analysis of the time series
 // data within a key called "series"
 type TimeSeriesResponse struct {
-	Metadata *sentiment.Analysis `json:"metadata,omitempty"`
-	Series   []TimeSeries        `json:"series"`
+	Metadata *AnalysisResponse `json:"metadata,omitempty"`
+	Series   []TimeSeries      `json:"series"`
 }
 
 // AnalyzeJSON holds the expected JSON
@@ -20,6 +23,17 @@ type TimeSeriesResponse struct {
 type AnalyzeJSON struct {
 	Text     string             `json:"text"`
 	Language sentiment.Language `json:"lang,omitempty"`
+
+	// Lexicon holds word overrides for this
+	// request only. These take precedence
+	// over the configured lexicons.
+	Lexicon Lexicon `json:"lexicon,omitempty"`
+
+	// Highlights is the number of most positive
+	// and most negative sentences to return
+	// within the analysis. None are returned
+	// if this is 0.
+	Highlights int `json:"highlights,omitempty"`
 }
 
 // TaskJSON holds a generic request
@@ -31,6 +45,67 @@ type AnalyzeJSON struct {
 type TaskJSON struct {
 	ID     string `json:"recordingId"`
 	HookID string `json:"hookId,omitempty"`
+
+	// IDs runs the task for each of the given
+	// ids (instead of ID) as a batch, returning
+	// a BatchResponse
+	IDs []string `json:"recordingIds,omitempty"`
+
+	// Highlights works the same as within
+	// the AnalyzeJSON
+	Highlights int `json:"highlights,omitempty"`
+
+	// Async queues the task to be run in the
+	// background. The response is a 202 with
+	// a job id which can be polled with
+	// GET /task/{id} for the result.
+	Async bool `json:"async,omitempty"`
+
+	// Callback is POSTed the result of the
+	// task when it completes. Giving a
+	// callback implies Async.
+	Callback *Callback `json:"callback,omitempty"`
+
+	// Params holds extra values for hooks
+	// with named URL placeholders or
+	// templated request bodies
+	Params map[string]string `json:"params,omitempty"`
+
+	// noCache fetches the hook's response
+	// from the upstream even when a cached
+	// one is fresh, for scheduled polls
+	noCache bool
+}
+
+// CompareJSON holds the expected JSON
+// request info for the POST /compare
+// endpoint. Each side of the comparison
+// is either given as text or fetched
+// from a hook.
+type CompareJSON struct {
+	A CompareDocument `json:"a"`
+	B CompareDocument `json:"b"`
+
+	// Limit caps the number of words and
+	// sentences returned as the largest
+	// differences. Defaults to 10.
+	Limit int `json:"limit,omitempty"`
+
+	Lexicon Lexicon `json:"lexicon,omitempty"`
+}
+
+// CompareDocument is one side of a
+// comparison. If Text is blank the
+// text is fetched from the hook given
+// by HookID (or the default hook) with
+// the record id ID, exactly like the
+// POST /task endpoint.
+type CompareDocument struct {
+	Text     string             `json:"text,omitempty"`
+	Language sentiment.Language `json:"lang,omitempty"`
+
+	ID     string `json:"recordingId,omitempty"`
+	HookID string `json:"hookId,omitempty"`
 }
 
 // Hook holds information for any
@@ -38,13 +113,115 @@ type TaskJSON struct {
 // want to make to the POST /task endpoint.
 //
 // When calling POST /task the user passes
-// an ID that is fmt.Sprintf'ed into the URL.
-// As such, the URL in this struct must have
-// some sort of %v type string formattable
-// value!
+// an ID that is formatted into the URL. The
+// URL can either have named placeholders,
+// like
+//
+//	https://example.com/orgs/{org}/tickets/{id}?page={page}
+//
+// where {id} is the ID and the others come
+// from the params given in the TaskJSON, or
+// (for older configs) one %v type string
+// formattable value which is fmt.Sprintf'ed
+// with the ID. Either way, values are escaped
+// so they can't change the rest of the URL.
+//
+// The URL and Headers can reference secrets
+// instead of holding them, as ${env:NAME} for
+// environment variables or ${file:/path} for
+// files. They are resolved when the hook is
+// loaded, which fails if any are missing.
 type Hook struct {
 	URL     string              `json:"url"`
 	Headers map[string][]string `json:"headers,omitempty"`
+	secrets map[string]string
+
+	// Client configures timeouts, limits, and the
+	// accepted statuses for the hook's requests.
+	// Upstream failures are responded to with a
+	// 502 (or a 504 for timeouts) holding the
+	// upstream status.
+	Client *ClientConfig `json:"client,omitempty"`
+	client *http.Client
+
+	// OAuth authenticates the hook's requests
+	// with bearer tokens from an OAuth2 client
+	// credentials grant. Tokens are cached and
+	// refreshed before they expire.
+	OAuth  *OAuthConfig `json:"oauth,omitempty"`
+	tokens *tokenSource
+
+	// Signing signs the hook's requests with
+	// an HMAC, and TLS configures a client
+	// certificate and trusted CAs for upstreams
+	// which use mutual TLS
+	Signing *SigningConfig `json:"signing,omitempty"`
+	TLS     *TLSConfig     `json:"tls,omitempty"`
+
+	// Outbound restricts the hosts and IP ranges
+	// the hook's requests can go to, on top of
+	// the global outbound policy. Requests can't
+	// go to private, loopback or link-local IPs
+	// unless a policy allows them, and formatting
+	// the id into the URL can't change its scheme
+	// or host.
+	Outbound *OutboundPolicy `json:"outbound,omitempty"`
+	outbound *OutboundPolicy
+	origin   string
+
+	// Retry configures retries of failed
+	// requests to the hook, and Breaker
+	// configures a circuit breaker which stops
+	// requests to an upstream which keeps failing
+	Retry   *RetryPolicy   `json:"retry,omitempty"`
+	Breaker *BreakerConfig `json:"breaker,omitempty"`
+
+	// Pagination fetches every page of the
+	// hook's response (up to a limit) and
+	// combines them before analysis
+	Pagination *PaginationConfig `json:"pagination,omitempty"`
+
+	// Concurrency limits the number of requests
+	// made to the hook at once when running
+	// batches of ids. Defaults to 4.
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Params declares validation rules and
+	// defaults for the params (including the
+	// "id") used in the URL and body
+	Params map[string]HookParam `json:"params,omitempty"`
+
+	// Method is the HTTP method used for the
+	// hook request. Defaults to GET.
+	Method string `json:"method,omitempty"`
+
+	// Body is a text/template for the body of
+	// the hook request (for POSTing to search
+	// or GraphQL APIs, for example.) It's given
+	// a HookTemplateData, so the record id is
+	// {{.ID}} and params from the TaskJSON are
+	// {{.Params.name}}. ContentType is sent
+	// with the body and defaults to
+	// application/json.
+	Body         string `json:"body,omitempty"`
+	ContentType  string `json:"contentType,omitempty"`
+	bodyTemplate *template.Template
+
+	// Format is the format of the hook's responses:
+	// "json" (the default, which also covers plain
+	// text,) "xml", "csv" or "feed" (RSS or Atom.)
+	// The keys and fields of XML hooks are
+	// XPath-like selectors (see CompileXPath,)
+	// the keys of CSV hooks name a column (with
+	// CSV configuring how it's read,) and each
+	// entry of a feed is analyzed as an item of
+	// a collection. "srt", "vtt" (WebVTT) and
+	// "whisper" (Whisper-style JSON segments)
+	// read transcripts as time series data,
+	// keeping any speaker labels (see
+	// ParseTranscript.)
+	Format string     `json:"format,omitempty"`
+	CSV    *CSVConfig `json:"csv,omitempty"`
 
 	// Lanugage holds the language code expected
 	// to come from the hook. Defaults to 'en'
@@ -63,7 +240,20 @@ type Hook struct {
 	// {
 	//   "key": "this is my text to be analyzed!"
 	// }
-	Key string `json:"key,omitempty"`
+	//
+	// Keys starting with $ are JSONPath
+	// expressions (see JSONPath) which can
+	// find nested or repeated text, like
+	// $.data.comments[*].body. Every match
+	// must be a string, and the matches are
+	// joined with spaces into one document.
+	Key     string `json:"key,omitempty"`
+	keyPath *JSONPath
+
+	// Separate also scores each text matched
+	// by the Key on its own, returning the
+	// analyses in "matches"
+	Separate bool `json:"separate,omitempty"`
 
 	// Time tells the hook request that the
 	// hook will return text within time
@@ -118,19 +308,50 @@ type Hook struct {
 	// no Key given, the hook will be expected
 	// to return an array of TimeSeries as the
 	// top level JSON object.
-	Time bool `json:"time,omitempty"`
+	//
+	// With a JSONPath Key, the key can match
+	// either the array of entries or each entry
+	// ($.results[*].segments[*]) and TimeFields
+	// can locate the start, end, text and
+	// speaker within each entry. Transcript
+	// formats (srt, vtt and whisper) give time
+	// series data without needing this flag.
+	Time       bool        `json:"time,omitempty"`
+	TimeFields *TimeFields `json:"timeFields,omitempty"`
+
+	// Collection treats the hook response as a
+	// list of separate documents (the comments
+	// on a thread, for example) found with the
+	// Key, or the top level array without one.
+	// Each is analyzed on its own and returned
+	// with its id in a CollectionResponse.
+	Collection *CollectionFields `json:"collection,omitempty"`
+
+	// Lexicon holds word overrides used when
+	// analyzing text from this hook. These
+	// take precedence over the global lexicon
+	// in the Configuration.
+	Lexicon Lexicon `json:"lexicon,omitempty"`
+
+	// Callback is the default callback for
+	// asynchronous tasks using this hook which
+	// don't give their own
+	Callback *Callback `json:"callback,omitempty"`
 }
 
 // TimeSeries holds the expected format
 // for time series data response. Look at
 // the Hook docs for Time to get a sense
-// of how this works.
+// of how this works. Speaker is the label
+// of whoever is speaking, when the
+// transcript gives one.
 type TimeSeries struct {
 	Start float64 `json:"start"`
 	End   float64 `json:"end"`
 
-	Text  string `json:"text"`
-	Score uint8  `json:"score"`
+	Speaker string `json:"speaker,omitempty"`
+	Text    string `json:"text"`
+	Score   uint8  `json:"score"`
 }
 
 // TimeSeriesRequest holds the expected
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenLeeway is how long before a token
+	// expires that it's refreshed, so it doesn't
+	// expire while a request is in flight
+	tokenLeeway = 30 * time.Second
+
+	// maxTokenResponseSize caps the size of
+	// token endpoint responses
+	maxTokenResponseSize = 1 << 20
+)
+
+// OAuthConfig configures a hook to authenticate
+// with OAuth2 bearer tokens from the client
+// credentials grant. The ClientID and
+// ClientSecret are sent to TokenURL with HTTP
+// basic auth, along with the Scopes (if any.)
+// Like the hook's URL and headers, they can
+// reference ${env:NAME} and ${file:/path}
+// secrets.
+type OAuthConfig struct {
+	TokenURL     string   `json:"tokenUrl"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+// tokenSource fetches and caches the bearer
+// tokens for a hook. It's shared between the
+// copies of the hook.
+type tokenSource struct {
+	sync.Mutex
+
+	config  OAuthConfig
+	client  *http.Client
+	token   string
+	expires time.Time
+}
+
+// tokenResponse is a token
+// endpoint's response
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Validate makes sure the config
+// has everything a token needs
+func (c *OAuthConfig) Validate() error {
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return fmt.Errorf("a client id and client secret must be given")
+	}
+
+	u, err := url.Parse(c.TokenURL)
+	if err != nil {
+		return fmt.Errorf("invalid token url '%v': %v", c.TokenURL, err)
+	}
+	if !u.IsAbs() || !(u.Scheme == "http" || u.Scheme == "https") {
+		return fmt.Errorf("token url '%v' must be an absolute http or https url", c.TokenURL)
+	}
+
+	return nil
+}
+
+// Token returns a valid token, fetching a
+// new one if there isn't one cached or the
+// cached one is about to expire. Tokens
+// given without an expiry are reused until
+// they're invalidated.
+func (s *tokenSource) Token() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.token != "" && (s.expires.IsZero() || time.Now().Before(s.expires)) {
+		return s.token, nil
+	}
+
+	return s.fetch()
+}
+
+// Invalidate drops the cached token, if it's
+// still the given one, so the next call to
+// Token fetches a fresh one
+func (s *tokenSource) Invalidate(token string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// fetch requests a new token from the token
+// endpoint. The caller must hold the lock.
+func (s *tokenSource) fetch() (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(s.config.Scopes) != 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTokenResponseSize))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint responded with status %v", resp.Status)
+	}
+
+	token := tokenResponse{}
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return "", fmt.Errorf("couldn't unmarshal token response: %v", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", fmt.Errorf("token type '%v' is not supported, expected 'bearer'", token.TokenType)
+	}
+
+	s.token = token.AccessToken
+	s.expires = time.Time{}
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		leeway := tokenLeeway
+		if leeway > lifetime/2 {
+			leeway = lifetime / 2
+		}
+		s.expires = time.Now().Add(lifetime - leeway)
+	}
+
+	return s.token, nil
+}
+
+// authorize adds the hook's bearer token to a
+// request, returning the token used. Hooks
+// without OAuth are left alone.
+func (h *Hook) authorize(id string, req *http.Request) (string, error) {
+	if h.tokens == nil {
+		return "", nil
+	}
+
+	token, err := h.tokens.Token()
+	if err != nil {
+		return "", &HookError{
+			Status:  http.StatusBadGateway,
+			Message: "ERROR: could not get an OAuth2 token for HOOK request",
+			Hook:    id,
+			Err:     h.redact(err.Error()),
+		}
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return token, nil
+}
+
+// unauthorized returns whether an error is
+// the upstream rejecting a request's token
+func unauthorized(err error) bool {
+	var hookErr *HookError
+	return errors.As(err, &hookErr) && hookErr.UpstreamStatus == http.StatusUnauthorized
+}
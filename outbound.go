@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// blockedNetworks are the loopback, private,
+// link-local (including cloud metadata
+// services), and otherwise special IP ranges
+// hooks can't connect to unless their
+// outbound policy allows them
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// OutboundPolicy restricts where hook requests
+// can go. When Hosts is given a request's host
+// must be one of them, where "*.example.com"
+// matches any subdomain of example.com.
+// Networks lists CIDR ranges which are allowed
+// even though they're blocked by default.
+//
+// The global policy in the config applies to
+// every hook, and a hook's own policy adds
+// to it.
+type OutboundPolicy struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Networks []string `json:"networks,omitempty"`
+	networks []*net.IPNet
+}
+
+// OutboundError is given when a request is
+// refused by an outbound policy
+type OutboundError struct {
+	Reason string
+}
+
+// Error gives the reason the
+// request was refused
+func (e *OutboundError) Error() string {
+	return e.Reason
+}
+
+// parseNetworks parses CIDR ranges
+// known to be valid
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+
+	return networks
+}
+
+// mergeOutbound combines the global and hook
+// policies into the policy used by the hook
+func mergeOutbound(policies ...*OutboundPolicy) (*OutboundPolicy, error) {
+	merged := &OutboundPolicy{}
+	for _, p := range policies {
+		if p == nil {
+			continue
+		}
+
+		merged.Hosts = append(merged.Hosts, p.Hosts...)
+		merged.Networks = append(merged.Networks, p.Networks...)
+	}
+
+	for i := range merged.Hosts {
+		merged.Hosts[i] = strings.ToLower(merged.Hosts[i])
+	}
+	for _, cidr := range merged.Networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%v': %v", cidr, err)
+		}
+		merged.networks = append(merged.networks, network)
+	}
+
+	return merged, nil
+}
+
+// AllowsURL returns why a URL is refused by
+// the policy, or nil if it's allowed
+func (p *OutboundPolicy) AllowsURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return &OutboundError{Reason: fmt.Sprintf("scheme '%v' is not allowed", u.Scheme)}
+	}
+
+	if len(p.Hosts) == 0 {
+		return nil
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range p.Hosts {
+		if host == allowed {
+			return nil
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+
+	return &OutboundError{Reason: fmt.Sprintf("host '%v' is not in the allowed hosts", host)}
+}
+
+// AllowsIP returns whether the policy
+// allows connecting to an IP
+func (p *OutboundPolicy) AllowsIP(ip net.IP) bool {
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// control is used as the hook dialer's Control
+// function. It's called with the resolved IP
+// right before connecting, so a host can't pass
+// the check and then resolve somewhere else
+// (DNS rebinding.)
+func (p *OutboundPolicy) control(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !p.AllowsIP(ip) {
+		return &OutboundError{Reason: fmt.Sprintf("connecting to %v is not allowed", host)}
+	}
+
+	return nil
+}
+
+// urlOrigin splits the scheme and host (with
+// any port) from the front of a URL template,
+// without parsing the rest of it
+func urlOrigin(template string) (string, string) {
+	scheme := ""
+	if i := strings.Index(template, "://"); i >= 0 {
+		scheme, template = template[:i], template[i+3:]
+	}
+
+	if i := strings.IndexAny(template, "/?#"); i >= 0 {
+		template = template[:i]
+	}
+	if i := strings.LastIndex(template, "@"); i >= 0 {
+		template = template[i+1:]
+	}
+
+	return strings.ToLower(scheme), strings.ToLower(template)
+}
+
+// prepareOutbound works out the hook's policy
+// and the origin its requests must go to. Hooks
+// whose host is filled in from params have no
+// fixed origin, so they must give an allowlist.
+func (h *Hook) prepareOutbound() error {
+	var global *OutboundPolicy
+	if Config != nil {
+		global = &Config.Outbound
+	}
+
+	var err error
+	h.outbound, err = mergeOutbound(global, h.Outbound)
+	if err != nil {
+		return err
+	}
+
+	scheme, host := urlOrigin(h.interpolate(h.URL))
+	h.origin = ""
+	if strings.ContainsAny(scheme+host, "%{}") {
+		if len(h.outbound.Hosts) == 0 {
+			return fmt.Errorf("hooks with params in their scheme or host must give allowed hosts")
+		}
+		return nil
+	}
+
+	h.origin = scheme + "://" + host
+	return nil
+}
+
+// allows checks a hook request against the
+// hook's policy, making sure formatting the
+// id and params into the URL didn't change
+// its scheme or host
+func (h *Hook) allows(id string, u *url.URL) error {
+	err := h.outbound.AllowsURL(u)
+	if err == nil && h.origin != "" && strings.ToLower(u.Scheme+"://"+u.Host) != h.origin {
+		err = &OutboundError{Reason: fmt.Sprintf("request to %v://%v doesn't match the hook's origin", u.Scheme, u.Host)}
+	}
+	if err != nil {
+		return &HookError{
+			Status:  http.StatusForbidden,
+			Message: "ERROR: HOOK request blocked by outbound policy",
+			Hook:    id,
+			Err:     err.Error(),
+			Blocked: true,
+		}
+	}
+
+	return nil
+}
+
+// checkRedirectOrigin makes the hook's client
+// refuse redirects away from the hook's origin,
+// since Go forwards the bearer token and
+// signed headers on redirects within a domain
+func (h *Hook) checkRedirectOrigin() {
+	origin := h.origin
+	if origin == "" {
+		return
+	}
+
+	check := h.client.CheckRedirect
+	h.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		err := check(req, via)
+		if err != nil {
+			return err
+		}
+
+		if strings.ToLower(req.URL.Scheme+"://"+req.URL.Host) != origin {
+			return &OutboundError{Reason: fmt.Sprintf("redirect to %v://%v doesn't match the hook's origin", req.URL.Scheme, req.URL.Host)}
+		}
+		return nil
+	}
+}
+
+// outboundBlocked returns whether an
+// error is an outbound policy refusal
+func outboundBlocked(err error) bool {
+	var outboundErr *OutboundError
+	return errors.As(err, &outboundErr)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PageLink follows a link to the next
+	// page found in each page's body
+	PageLink = "link"
+
+	// PageHeader follows the rel="next"
+	// link in each page's Link header
+	PageHeader = "header"
+
+	// PageNumber counts up a page number
+	// query param until a page is empty
+	PageNumber = "page"
+
+	// PageOffset moves an offset query param
+	// past each page's items until a page
+	// is empty
+	PageOffset = "offset"
+
+	// PageCursor passes the cursor found in
+	// each page's body as a query param
+	PageCursor = "cursor"
+)
+
+// PaginationConfig makes a hook fetch every
+// page of a response and combine them before
+// analysis. Type is the pagination strategy:
+//
+// "link" follows the next page's URL (which
+// can be relative) found at the Next JSONPath
+// in each page's body.
+//
+// "header" follows the rel="next" link in each
+// page's Link header.
+//
+// "page" sets the Param query param (defaults
+// to "page") to the page number, starting from
+// First (defaults to 1.)
+//
+// "offset" sets the Param query param (defaults
+// to "offset") to the number of items fetched
+// so far, plus First. It needs a Key, a
+// Collection or a time series to count the
+// items by.
+//
+// "cursor" sets the Param query param (defaults
+// to "cursor") to the cursor found at the Next
+// JSONPath in the previous page's body.
+//
+// Link and cursor pagination stop when there's
+// no next link or cursor, and every strategy
+// stops at the first empty page (one where the
+// key matches nothing, or with a blank body for
+// hooks without a key.) At most MaxPages pages
+// are fetched (defaults to 10.)
+type PaginationConfig struct {
+	Type     string `json:"type"`
+	Next     string `json:"next,omitempty"`
+	Param    string `json:"param,omitempty"`
+	First    *int   `json:"first,omitempty"`
+	MaxPages int    `json:"maxPages,omitempty"`
+
+	next *JSONPath
+}
+
+// prepare validates the pagination
+// config and fills in the defaults
+func (p *PaginationConfig) prepare() error {
+	p.next = nil
+
+	switch p.Type {
+	case PageLink, PageCursor:
+		if p.Next == "" {
+			return fmt.Errorf("%v pagination must give the next path", p.Type)
+		}
+
+		var err error
+		p.next, err = CompileJSONPath(p.Next)
+		if err != nil {
+			return fmt.Errorf("invalid next: %v", err)
+		}
+	case PageHeader, PageNumber, PageOffset:
+	default:
+		return fmt.Errorf("unknown pagination type '%v' (expected 'link', 'header', 'page', 'offset' or 'cursor')", p.Type)
+	}
+
+	if p.Param == "" && p.Type != PageLink && p.Type != PageHeader {
+		p.Param = p.Type
+	}
+
+	if p.First == nil {
+		first := 0
+		if p.Type == PageNumber {
+			first = 1
+		}
+		p.First = &first
+	}
+
+	if p.MaxPages < 0 {
+		return fmt.Errorf("maxPages must not be negative")
+	}
+	if p.MaxPages == 0 {
+		p.MaxPages = 10
+	}
+
+	return nil
+}
+
+// fetchPages fetches every page of a paginated
+// hook's response for a task, starting with
+// the given request, and combines them
+func (h *Hook) fetchPages(id string, j TaskJSON, req *http.Request) (*HookResponse, error) {
+	p := h.Pagination
+	first := *req.URL
+	if p.Type == PageNumber || p.Type == PageOffset {
+		req.URL = withQueryParam(&first, p.Param, strconv.Itoa(*p.First))
+	}
+
+	pages := []*HookResponse{}
+	seen := 0
+	for page := 1; ; page++ {
+		key := fetchKey(id, j)
+		if page > 1 && key != "" {
+			key += "#" + h.redact(req.URL.String())
+		}
+
+		resp, err := h.fetch(id, key, req)
+		if err != nil {
+			return nil, err
+		}
+
+		var body interface{}
+		if p.next != nil || h.Key != "" || h.Collection != nil || h.Time {
+			// bodies which can't be decoded
+			// are reported by Extract
+			body, _ = h.decode(resp.Body)
+		}
+
+		size := h.pageSize(resp.Body, body)
+		if page > 1 && size == 0 {
+			break
+		}
+
+		r, err := h.Extract(id, resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, r)
+		seen += size
+
+		if page == p.MaxPages {
+			break
+		}
+
+		next, err := h.nextPage(id, req.URL, &first, resp, body, page, seen)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil || next.String() == req.URL.String() {
+			break
+		}
+
+		req, err = h.NewRequest(j)
+		if err != nil {
+			return nil, fmt.Errorf(`{"message": "ERROR: unable to build the HOOK request from the configured hook", "hookUrl": "%v", "id": "%v", "error": "%v"}`, h.URL, id, err)
+		}
+		req.URL = next
+		req.Host = next.Host
+	}
+
+	return combinePages(pages), nil
+}
+
+// nextPage returns the URL of the page after
+// the one at current, or nil if it was the
+// last page. first is the URL of the first
+// page, page is the number of pages fetched
+// so far and seen is the number of items
+// within them.
+func (h *Hook) nextPage(id string, current, first *url.URL, resp *UpstreamResponse, body interface{}, page, seen int) (*url.URL, error) {
+	p := h.Pagination
+
+	switch p.Type {
+	case PageNumber:
+		return withQueryParam(first, p.Param, strconv.Itoa(*p.First+page)), nil
+
+	case PageOffset:
+		return withQueryParam(first, p.Param, strconv.Itoa(*p.First+seen)), nil
+
+	case PageHeader:
+		link := nextLink(resp.Header.Get("Link"))
+		if link == "" {
+			return nil, nil
+		}
+		return resolvePage(id, current, link)
+	}
+
+	value := ""
+	if matches := p.next.Find(body); len(matches) != 0 {
+		switch v := matches[0].(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case nil:
+		default:
+			return nil, fmt.Errorf(`{"message": "ERROR: the next page given by the HOOK request is not a string", "hook": "%v", "next": "%v"}`, id, p.Next)
+		}
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	if p.Type == PageCursor {
+		return withQueryParam(first, p.Param, value), nil
+	}
+	return resolvePage(id, current, value)
+}
+
+// countsItems returns whether the hook's
+// pages are made of items which can be
+// counted, rather than plain text
+func (h *Hook) countsItems() bool {
+	return h.Key != "" || h.Collection != nil || h.Time
+}
+
+// pageSize returns the number of values a
+// page's key matches (counting each element
+// when it matches a single array,) or the
+// length of the trimmed body for hooks
+// without a key
+func (h *Hook) pageSize(data []byte, body interface{}) int {
+	if !h.countsItems() {
+		return len(strings.TrimSpace(string(data)))
+	}
+
+	values := []interface{}{body}
+	if h.Key != "" {
+		values = h.keyPath.Find(body)
+	}
+	if len(values) == 1 {
+		if arr, ok := values[0].([]interface{}); ok {
+			return len(arr)
+		}
+	}
+
+	return len(values)
+}
+
+// withQueryParam returns a copy of
+// u with the query param set
+func withQueryParam(u *url.URL, name, value string) *url.URL {
+	copied := *u
+	query := copied.Query()
+	query.Set(name, value)
+	copied.RawQuery = query.Encode()
+
+	return &copied
+}
+
+// resolvePage resolves the link to a
+// page against the current page's URL
+func resolvePage(id string, current *url.URL, link string) (*url.URL, error) {
+	next, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf(`{"message": "ERROR: the next page given by the HOOK request is not a valid URL", "hook": "%v", "error": "%v"}`, id, err)
+	}
+
+	return current.ResolveReference(next), nil
+}
+
+// nextLink returns the rel="next" target
+// within a Link header, or "" if it
+// doesn't have one
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			name, value := param, ""
+			if i := strings.Index(param, "="); i >= 0 {
+				name, value = param[:i], param[i+1:]
+			}
+			if !strings.EqualFold(strings.TrimSpace(name), "rel") {
+				continue
+			}
+
+			for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+				if strings.EqualFold(rel, "next") {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// combinePages joins the responses
+// of each page into one
+func combinePages(pages []*HookResponse) *HookResponse {
+	r := &HookResponse{
+		Series:   pages[0].Series,
+		Items:    pages[0].Items,
+		Matches:  pages[0].Matches,
+		Language: pages[0].Language,
+		RawTimes: pages[0].RawTimes,
+	}
+
+	texts := []string{pages[0].Text}
+	for _, page := range pages[1:] {
+		r.Series = append(r.Series, page.Series...)
+		r.Items = append(r.Items, page.Items...)
+		r.Matches = append(r.Matches, page.Matches...)
+		texts = append(texts, page.Text)
+	}
+	r.Text = strings.Join(texts, " ")
+
+	return r
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// BreakerClosed means requests flow to
+	// the hook's upstream as normal
+	BreakerClosed = "closed"
+
+	// BreakerOpen means the upstream has failed
+	// too many times in a row, so requests fail
+	// fast until the cooldown passes
+	BreakerOpen = "open"
+
+	// BreakerHalfOpen means the cooldown passed
+	// and a single trial request is being let
+	// through to see if the upstream recovered
+	BreakerHalfOpen = "half-open"
+)
+
+var (
+	// breakers holds the circuit breaker for
+	// each hook which has one configured
+	breakers     = map[string]*Breaker{}
+	breakersLock sync.Mutex
+)
+
+// RetryPolicy configures retries of failed hook
+// requests. MaxAttempts is the total number of
+// attempts (defaults to 1, so no retries.) The
+// wait before each retry starts at Backoff and
+// doubles up to MaxBackoff (defaults to 100ms
+// and 5s,) with random jitter of up to half the
+// wait. Requests are retried when they can't be
+// completed or time out, or when the upstream
+// responds with a status in RetryOn (defaults to
+// 502, 503, and 504, and can only hold 429 and
+// 5xx statuses.) Only idempotent methods are
+// retried unless RetryUnsafe is set, so a POST
+// isn't sent upstream more than once.
+type RetryPolicy struct {
+	MaxAttempts int      `json:"maxAttempts,omitempty"`
+	Backoff     Duration `json:"backoff,omitempty"`
+	MaxBackoff  Duration `json:"maxBackoff,omitempty"`
+	RetryOn     []int    `json:"retryOn,omitempty"`
+	RetryUnsafe bool     `json:"retryUnsafe,omitempty"`
+}
+
+// BreakerConfig configures a hook's circuit
+// breaker. It opens after Failures consecutive
+// failed requests (defaults to 5) and stays
+// open for Cooldown (defaults to 30s) before
+// letting a trial request through.
+type BreakerConfig struct {
+	Failures int      `json:"failures,omitempty"`
+	Cooldown Duration `json:"cooldown,omitempty"`
+}
+
+// Breaker is a circuit breaker for a
+// hook's upstream
+type Breaker struct {
+	sync.Mutex
+
+	config   BreakerConfig
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// BreakerState is a snapshot of a Breaker,
+// given on the status endpoint and in the
+// errors of requests the breaker rejects
+type BreakerState struct {
+	State    string     `json:"state"`
+	Failures int        `json:"consecutiveFailures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+	RetryAt  *time.Time `json:"retryAt,omitempty"`
+}
+
+// withDefaults fills in the default
+// retry settings
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = Duration(100 * time.Millisecond)
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = Duration(5 * time.Second)
+	}
+	if len(p.RetryOn) == 0 {
+		p.RetryOn = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+
+	return p
+}
+
+// validate makes sure the policy only
+// retries statuses worth retrying
+func (p RetryPolicy) validate() error {
+	for _, status := range p.RetryOn {
+		if status != http.StatusTooManyRequests && (status < 500 || status > 599) {
+			return fmt.Errorf("can't retry on status %v, only 429 and 5xx statuses", status)
+		}
+	}
+
+	return nil
+}
+
+// retries returns whether the policy retries
+// the given error for a request with the given
+// method. Only transport failures and statuses
+// in RetryOn are retried, never local failures
+// (like signing) or requests blocked by an
+// outbound policy.
+func (p RetryPolicy) retries(method string, err error) bool {
+	if !p.RetryUnsafe && !idempotent(method) {
+		return false
+	}
+
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || hookErr.Blocked {
+		return false
+	}
+	if hookErr.transport {
+		return true
+	}
+	if hookErr.UpstreamStatus == 0 {
+		return false
+	}
+
+	for _, status := range p.RetryOn {
+		if hookErr.UpstreamStatus == status {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotent returns whether a request with
+// the given method can be sent more than once
+// without changing its outcome
+func idempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// wait returns how long to wait before the
+// given retry (starting at 1)
+func (p RetryPolicy) wait(retry int) time.Duration {
+	wait := time.Duration(p.Backoff)
+	for i := 1; i < retry && wait < time.Duration(p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if wait > time.Duration(p.MaxBackoff) {
+		wait = time.Duration(p.MaxBackoff)
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// withDefaults fills in the default
+// breaker settings
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.Failures < 1 {
+		c.Failures = 5
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = Duration(30 * time.Second)
+	}
+
+	return c
+}
+
+// hookBreaker returns the breaker for the hook
+// with the given id, or nil if it has none. The
+// breaker is reset if its config has changed.
+func hookBreaker(id string, hook *Hook) *Breaker {
+	if hook.Breaker == nil {
+		return nil
+	}
+
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	b, ok := breakers[id]
+	if !ok || b.config != *hook.Breaker {
+		b = &Breaker{
+			config: *hook.Breaker,
+			state:  BreakerClosed,
+		}
+		breakers[id] = b
+	}
+
+	return b
+}
+
+// BreakerStates returns a snapshot of the state
+// of every hook's breaker, by hook id
+func BreakerStates() map[string]BreakerState {
+	breakersLock.Lock()
+	ids := make([]string, 0, len(breakers))
+	for id := range breakers {
+		ids = append(ids, id)
+	}
+	breakersLock.Unlock()
+	sort.Strings(ids)
+
+	states := map[string]BreakerState{}
+	for _, id := range ids {
+		breakersLock.Lock()
+		b := breakers[id]
+		breakersLock.Unlock()
+
+		states[id] = b.State()
+	}
+
+	return states
+}
+
+// Allow returns whether a request may be made.
+// Once the cooldown of an open breaker passes a
+// single trial request is allowed through.
+func (b *Breaker) Allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < time.Duration(b.config.Cooldown) {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return true
+
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+
+	return true
+}
+
+// Record records the outcome of a request
+// the breaker allowed
+func (b *Breaker) Record(failed bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.trial = false
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.config.Failures {
+		b.state = BreakerOpen
+		b.openedAt = time.Now().UTC()
+	}
+}
+
+// State returns a snapshot of the breaker
+func (b *Breaker) State() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+
+	state := BreakerState{
+		State:    b.state,
+		Failures: b.failures,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := b.openedAt.Add(time.Duration(b.config.Cooldown))
+		state.OpenedAt = &openedAt
+		state.RetryAt = &retryAt
+	}
+
+	return state
+}
+
+// upstreamFailed returns whether an error means
+// the upstream itself is unhealthy (rather than,
+// say, refusing our auth) and so counts against
+// its breaker
+func upstreamFailed(err error) bool {
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || hookErr.Blocked {
+		return false
+	}
+
+	return hookErr.UpstreamStatus == 0 || hookErr.UpstreamStatus >= 500
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AlertBelow is the reason given when
+	// the rolling sentiment drops below the
+	// alert's Below threshold
+	AlertBelow = "below"
+
+	// AlertAbove is the reason given when
+	// the rolling sentiment rises above the
+	// alert's Above threshold
+	AlertAbove = "above"
+
+	// AlertChange is the reason given when
+	// the rolling sentiment moves by at least
+	// the alert's Change in a single run
+	AlertChange = "change"
+
+	// maxAlerts is the number of alerts
+	// kept in a schedule's state
+	maxAlerts = 20
+)
+
+var (
+	// schedules runs the configured
+	// scheduled tasks, if there are any
+	schedules *Scheduler
+)
+
+// ScheduleConfig runs a task against a hook on
+// a fixed Interval or a Cron expression (in the
+// server's local time.) Interval schedules first
+// run when the server starts.
+//
+// Each run's analysis is kept in the schedule's
+// score history, which holds the last History
+// scores (defaults to 100.) The rolling sentiment
+// is the mean confidence (the probability the
+// text is positive) of the last Window scores
+// (defaults to 5.)
+type ScheduleConfig struct {
+	HookID string            `json:"hookId,omitempty"`
+	ID     string            `json:"recordingId"`
+	Params map[string]string `json:"params,omitempty"`
+
+	Interval Duration `json:"interval,omitempty"`
+	Cron     string   `json:"cron,omitempty"`
+
+	History int `json:"history,omitempty"`
+	Window  int `json:"window,omitempty"`
+
+	Alert *AlertConfig `json:"alert,omitempty"`
+
+	cron *CronSchedule
+}
+
+// AlertConfig is a callback which is POSTed an
+// Alert when a schedule's rolling sentiment
+// crosses below Below or above Above, or
+// changes by at least Change in one run.
+// Threshold alerts fire once when the rolling
+// sentiment crosses the threshold, and again
+// only after it has crossed back.
+//
+// Alerts are delivered (and signed) like
+// job callbacks.
+type AlertConfig struct {
+	Callback
+
+	Below  *float64 `json:"below,omitempty"`
+	Above  *float64 `json:"above,omitempty"`
+	Change float64  `json:"change,omitempty"`
+}
+
+// ScoreRecord is the outcome of a
+// successful scheduled run
+type ScoreRecord struct {
+	Time       time.Time `json:"time"`
+	Score      uint8     `json:"score"`
+	Confidence float64   `json:"confidence"`
+	Rolling    float64   `json:"rolling"`
+}
+
+// Alert is the body POSTed to a schedule's
+// alert callback. Previous is the rolling
+// sentiment before the run which fired it.
+type Alert struct {
+	Schedule    string    `json:"schedule"`
+	HookID      string    `json:"hookId"`
+	RecordingID string    `json:"recordingId"`
+	Reason      string    `json:"reason"`
+	Threshold   float64   `json:"threshold"`
+	Rolling     float64   `json:"rolling"`
+	Previous    *float64  `json:"previous,omitempty"`
+	Confidence  float64   `json:"confidence"`
+	Time        time.Time `json:"time"`
+
+	// Delivery records the attempts at
+	// POSTing the alert
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// ScheduleState is the state of a scheduled
+// task given by the admin API
+type ScheduleState struct {
+	ID          string    `json:"id"`
+	HookID      string    `json:"hookId"`
+	RecordingID string    `json:"recordingId"`
+	Interval    *Duration `json:"interval,omitempty"`
+	Cron        string    `json:"cron,omitempty"`
+
+	Running   bool            `json:"running"`
+	Runs      int             `json:"runs"`
+	Failures  int             `json:"failures"`
+	LastRun   *time.Time      `json:"lastRun,omitempty"`
+	NextRun   *time.Time      `json:"nextRun,omitempty"`
+	LastError json.RawMessage `json:"lastError,omitempty"`
+
+	Rolling *float64      `json:"rolling,omitempty"`
+	History []ScoreRecord `json:"history"`
+	Alerts  []*Alert      `json:"alerts"`
+}
+
+// Schedule is a running scheduled task
+type Schedule struct {
+	config ScheduleConfig
+	state  ScheduleState
+
+	// below and above are whether the rolling
+	// sentiment is currently past the alert's
+	// thresholds
+	below, above bool
+}
+
+// Scheduler runs every configured
+// schedule in the background
+type Scheduler struct {
+	sync.Mutex
+
+	schedules map[string]*Schedule
+	callbacks CallbacksConfig
+}
+
+// Validate makes sure the schedule has a
+// task, exactly one of an interval or cron
+// expression, and sensible alert thresholds
+func (c *ScheduleConfig) Validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("a recordingId must be given")
+	}
+
+	if (c.Interval > 0) == (c.Cron != "") {
+		return fmt.Errorf("exactly one of an interval or a cron expression must be given")
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("the interval must be positive")
+	}
+	if c.Cron != "" {
+		var err error
+		c.cron, err = ParseCron(c.Cron)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.History < 0 || c.Window < 0 {
+		return fmt.Errorf("history and window must not be negative")
+	}
+	if c.History == 0 {
+		c.History = 100
+	}
+	if c.Window == 0 {
+		c.Window = 5
+	}
+	if c.Window > c.History {
+		return fmt.Errorf("the window (%v) can't be longer than the history (%v)", c.Window, c.History)
+	}
+
+	if c.Alert == nil {
+		return nil
+	}
+
+	err := c.Alert.Validate()
+	if err != nil {
+		return err
+	}
+	if c.Alert.Below == nil && c.Alert.Above == nil && c.Alert.Change == 0 {
+		return fmt.Errorf("alerts must give a below or above threshold or a change")
+	}
+	for _, threshold := range []*float64{c.Alert.Below, c.Alert.Above} {
+		if threshold != nil && (*threshold < 0 || *threshold > 1) {
+			return fmt.Errorf("alert thresholds must be between 0 and 1")
+		}
+	}
+	if c.Alert.Change < 0 || c.Alert.Change > 1 {
+		return fmt.Errorf("alert changes must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// next returns when the schedule
+// next runs after t
+func (c *ScheduleConfig) next(t time.Time) time.Time {
+	if c.cron != nil {
+		return c.cron.Next(t)
+	}
+
+	return t.Add(time.Duration(c.Interval))
+}
+
+// NewScheduler starts running the given
+// (validated) schedules
+func NewScheduler(configs map[string]ScheduleConfig, callbacks CallbacksConfig) *Scheduler {
+	s := &Scheduler{
+		schedules: map[string]*Schedule{},
+		callbacks: callbacks.withDefaults(),
+	}
+
+	for id, c := range configs {
+		schedule := &Schedule{
+			config: c,
+			state: ScheduleState{
+				ID:          id,
+				HookID:      c.HookID,
+				RecordingID: c.ID,
+				Cron:        c.Cron,
+				History:     []ScoreRecord{},
+				Alerts:      []*Alert{},
+			},
+		}
+		if c.cron == nil {
+			interval := c.Interval
+			schedule.state.Interval = &interval
+		}
+
+		s.schedules[id] = schedule
+		go s.run(schedule)
+	}
+
+	return s
+}
+
+// run performs a schedule's task
+// at each of its times forever
+func (s *Scheduler) run(schedule *Schedule) {
+	next := time.Now()
+	if schedule.config.cron != nil {
+		next = schedule.config.next(next)
+	}
+
+	for {
+		s.Lock()
+		at := next.UTC()
+		schedule.state.NextRun = &at
+		s.Unlock()
+
+		time.Sleep(time.Until(next))
+		s.poll(schedule)
+
+		// runs which overran the interval
+		// are skipped rather than queued
+		next = schedule.config.next(next)
+		if now := time.Now(); next.Before(now) {
+			next = schedule.config.next(now)
+		}
+	}
+}
+
+// poll runs a schedule's task once, records
+// its score and fires any alerts
+func (s *Scheduler) poll(schedule *Schedule) {
+	c := schedule.config
+	id := schedule.state.ID
+
+	s.Lock()
+	schedule.state.Running = true
+	s.Unlock()
+
+	// a cached response would score the same
+	// content again as if it were new
+	r, err := RunTask(TaskJSON{
+		ID:      c.ID,
+		HookID:  c.HookID,
+		Params:  c.Params,
+		noCache: true,
+	})
+	now := time.Now().UTC()
+
+	s.Lock()
+	defer s.Unlock()
+
+	schedule.state.Running = false
+	schedule.state.Runs++
+	schedule.state.LastRun = &now
+
+	if err != nil {
+		schedule.state.Failures++
+		schedule.state.LastError = errorJSON(err)
+		log.Printf("SCHEDULE %v > ERROR: error running task\n\t%v\n", id, err)
+		return
+	}
+	schedule.state.LastError = nil
+
+	previous := schedule.state.Rolling
+	record := ScoreRecord{
+		Time:       now,
+		Score:      r.Analysis.Score,
+		Confidence: Confidence(r.Analysis.Words),
+	}
+
+	history := append(schedule.state.History, record)
+	if len(history) > c.History {
+		history = history[len(history)-c.History:]
+	}
+
+	window := history
+	if len(window) > c.Window {
+		window = window[len(window)-c.Window:]
+	}
+	total := 0.0
+	for _, w := range window {
+		total += w.Confidence
+	}
+	rolling := total / float64(len(window))
+
+	history[len(history)-1].Rolling = rolling
+	schedule.state.History = history
+	schedule.state.Rolling = &rolling
+
+	log.Printf("SCHEDULE %v [score = %v, rolling = %.3f]\n", id, record.Score, rolling)
+
+	if c.Alert == nil {
+		return
+	}
+
+	for _, alert := range schedule.alerts(previous, rolling) {
+		alert.Schedule = id
+		alert.HookID = c.HookID
+		alert.RecordingID = c.ID
+		alert.Rolling = rolling
+		alert.Previous = previous
+		alert.Confidence = record.Confidence
+		alert.Time = now
+
+		body, err := json.Marshal(alert)
+		if err != nil {
+			log.Printf("SCHEDULE %v > ERROR: error marshalling alert\n\t%v\n", id, err)
+			continue
+		}
+
+		alert.Delivery = &Delivery{
+			URL:      c.Alert.URL,
+			Status:   DeliveryPending,
+			Attempts: []DeliveryAttempt{},
+		}
+		schedule.state.Alerts = append(schedule.state.Alerts, alert)
+		if len(schedule.state.Alerts) > maxAlerts {
+			schedule.state.Alerts = schedule.state.Alerts[1:]
+		}
+
+		log.Printf("SCHEDULE %v [alert = %v, rolling = %.3f]\n", id, alert.Reason, rolling)
+		go s.deliver(alert, c.Alert.Callback, body)
+	}
+}
+
+// alerts returns the alerts fired by the rolling
+// sentiment moving from previous (nil on the
+// first run) to rolling, and updates whether it's
+// past the thresholds. The caller must hold the
+// scheduler's lock.
+func (schedule *Schedule) alerts(previous *float64, rolling float64) []*Alert {
+	a := schedule.config.Alert
+	alerts := []*Alert{}
+
+	if a.Below != nil {
+		below := rolling < *a.Below
+		if below && !schedule.below {
+			alerts = append(alerts, &Alert{Reason: AlertBelow, Threshold: *a.Below})
+		}
+		schedule.below = below
+	}
+
+	if a.Above != nil {
+		above := rolling > *a.Above
+		if above && !schedule.above {
+			alerts = append(alerts, &Alert{Reason: AlertAbove, Threshold: *a.Above})
+		}
+		schedule.above = above
+	}
+
+	if a.Change > 0 && previous != nil && math.Abs(rolling-*previous) >= a.Change {
+		alerts = append(alerts, &Alert{Reason: AlertChange, Threshold: a.Change})
+	}
+
+	return alerts
+}
+
+// deliver POSTs an alert to its callback,
+// recording each attempt on the alert
+func (s *Scheduler) deliver(alert *Alert, callback Callback, body []byte) {
+	headers := map[string]string{
+		"X-Sentiment-Schedule": alert.Schedule,
+		"X-Sentiment-Alert":    alert.Reason,
+	}
+
+	retryDelivery(s.callbacks, func(client *http.Client) (int, error) {
+		return postCallback(client, callback, body, s.callbacks.Secret, headers)
+	}, func(attempt int, record DeliveryAttempt, status string) {
+		s.Lock()
+		alert.Delivery.Attempts = append(alert.Delivery.Attempts, record)
+		alert.Delivery.Status = status
+		s.Unlock()
+
+		if record.Error != "" {
+			log.Printf("SCHEDULE %v > ERROR: alert attempt %v failed\n\t%v\n", alert.Schedule, attempt, record.Error)
+		}
+	})
+}
+
+// States returns a snapshot of the
+// state of every schedule
+func (s *Scheduler) States() map[string]ScheduleState {
+	states := map[string]ScheduleState{}
+	if s == nil {
+		return states
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for id, schedule := range s.schedules {
+		state := schedule.state
+		state.History = append([]ScoreRecord{}, state.History...)
+
+		state.Alerts = make([]*Alert, len(state.Alerts))
+		for i, alert := range schedule.state.Alerts {
+			copied := *alert
+			delivery := *alert.Delivery
+			delivery.Attempts = append([]DeliveryAttempt{}, alert.Delivery.Attempts...)
+			copied.Delivery = &delivery
+			state.Alerts[i] = &copied
+		}
+
+		states[id] = state
+	}
+
+	return states
+}
+
+// HandleSchedules gives the state of every
+// schedule (GET /schedules) or of the one
+// given in the path (GET /schedules/{id})
+func HandleSchedules(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	var resp interface{}
+	states := schedules.States()
+
+	id := strings.Trim(strings.TrimPrefix(req.URL.Path, "/schedules"), "/")
+	if id == "" {
+		resp = struct {
+			Schedules map[string]ScheduleState `json:"schedules"`
+		}{
+			Schedules: states,
+		}
+	} else {
+		state, ok := states[id]
+		if !ok {
+			r.WriteHeader(http.StatusNotFound)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: schedule given was not found in your configured schedules!", "id": "%v"}`, id)))
+			log.Printf("GET /schedules/%v > ERROR: schedule not found\n", id)
+			return
+		}
+		resp = state
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error marshalling schedules", "error": "%v"}`, err.Error())))
+		log.Printf("GET /schedules > ERROR: error marshalling schedules\n\t%v\n", err)
+		return
+	}
+
+	r.WriteHeader(http.StatusOK)
+	r.Write(data)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretReference matches references to
+// secrets within hook URLs and headers, like
+// ${env:COMMENTS_TOKEN} or ${file:/run/secrets/token}
+var secretReference = regexp.MustCompile(`\$\{([a-z]+):([^}]*)\}`)
+
+// ResolveSecret returns the value of the secret
+// with the given kind and name. Environment
+// secrets ("env") are read from the variable of
+// that name, and file secrets ("file") from the
+// file at that path, without trailing newlines.
+func ResolveSecret(kind, name string) (string, error) {
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret ${env:%v} references an environment variable which isn't set", name)
+		}
+		return value, nil
+
+	case "file":
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("secret ${file:%v} couldn't be read: %v", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	return "", fmt.Errorf("secret ${%v:%v} has unknown kind '%v' (expected 'env' or 'file')", kind, name, kind)
+}
+
+// InterpolateSecrets replaces the secret
+// references in s with their values
+func InterpolateSecrets(s string) (string, error) {
+	var err error
+	resolved := secretReference.ReplaceAllStringFunc(s, func(reference string) string {
+		match := secretReference.FindStringSubmatch(reference)
+
+		value, resolveErr := ResolveSecret(match[1], match[2])
+		if resolveErr != nil && err == nil {
+			err = resolveErr
+		}
+		return value
+	})
+
+	return resolved, err
+}
+
+// resolveSecrets resolves every secret referenced
+// by the hook's URL, headers, OAuth2 client
+// credentials and signing secret, so requests
+// can be made without touching the environment
+// or disk, and a missing secret is caught when
+// the hook is loaded rather than on its first
+// request
+func (h *Hook) resolveSecrets() error {
+	h.secrets = map[string]string{}
+
+	for _, source := range h.secretSources() {
+		for _, match := range secretReference.FindAllStringSubmatch(source, -1) {
+			if _, ok := h.secrets[match[0]]; ok {
+				continue
+			}
+
+			value, err := ResolveSecret(match[1], match[2])
+			if err != nil {
+				return err
+			}
+			h.secrets[match[0]] = value
+		}
+	}
+
+	return nil
+}
+
+// secretSources returns the parts of
+// the hook which can reference secrets
+func (h *Hook) secretSources() []string {
+	sources := []string{h.URL}
+	for _, values := range h.Headers {
+		sources = append(sources, values...)
+	}
+	if h.OAuth != nil {
+		sources = append(sources, h.OAuth.TokenURL, h.OAuth.ClientID, h.OAuth.ClientSecret)
+	}
+	if h.Signing != nil {
+		sources = append(sources, h.Signing.Secret)
+	}
+
+	return sources
+}
+
+// checkAPISecrets makes sure a hook given
+// through the API only references the secrets
+// the admin config allows. Secrets are meant
+// for hooks written by the operator, and an
+// API caller could otherwise send the server's
+// environment or files to a host they control.
+func (h *Hook) checkAPISecrets() error {
+	allowed := map[string]bool{}
+	if Config != nil && Config.Admin != nil {
+		for _, reference := range Config.Admin.Secrets {
+			allowed[reference] = true
+		}
+	}
+
+	for _, source := range h.secretSources() {
+		for _, reference := range secretReference.FindAllString(source, -1) {
+			if !allowed[reference] {
+				return fmt.Errorf("secret %v isn't allowed in hooks given through the API", reference)
+			}
+		}
+	}
+
+	return nil
+}
+
+// interpolate replaces the secret
+// references in s with their values
+func (h *Hook) interpolate(s string) string {
+	if len(h.secrets) == 0 {
+		return s
+	}
+
+	return secretReference.ReplaceAllStringFunc(s, func(reference string) string {
+		return h.secrets[reference]
+	})
+}
+
+// redact replaces any secret values within s
+// (like a URL in an upstream error) with their
+// references, so they aren't given to clients
+func (h *Hook) redact(s string) string {
+	for reference, value := range h.secrets {
+		if value != "" {
+			s = strings.Replace(s, value, reference, -1)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cdipaolo/sentiment"
+)
+
+const (
+	// EngineSegmenter splits sentences with
+	// the sentiment engine's own splitter
+	EngineSegmenter = "engine"
+
+	// ServerSegmenter splits sentences with
+	// SegmentSentences, which knows about
+	// abbreviations, decimals, and quotes
+	ServerSegmenter = "server"
+)
+
+// TitleAbbreviations holds the built in
+// abbreviations (lowercased, without their
+// trailing period) which come before a name
+// ("Dr. Smith",) so they never end a sentence.
+var TitleAbbreviations = map[sentiment.Language][]string{
+	sentiment.English: {
+		"mr", "mrs", "ms", "dr", "prof", "mt", "rev",
+		"gov", "sen", "lt", "sgt", "capt", "cmdr",
+	},
+}
+
+// Abbreviations holds the other built in
+// abbreviations by language. These don't end
+// a sentence unless they're followed by a
+// capitalized word ("at 5 p.m. Then we left".)
+// More can be given in the Configuration.
+var Abbreviations = map[sentiment.Language][]string{
+	sentiment.English: {
+		"sr", "jr", "vs", "vol", "approx", "dept", "ave", "blvd",
+		"jan", "feb", "apr", "jun", "jul", "aug", "sep", "sept",
+		"oct", "nov", "dec", "mon", "tue", "tues", "thu", "thurs",
+		"fri", "e.g", "i.e", "a.m", "p.m", "cf",
+	},
+}
+
+// AmbiguousAbbreviations holds the built in
+// abbreviations which are also ordinary words
+// ("no", "sun".) These only count as
+// abbreviations when they're capitalized and
+// followed by a number or a lowercase word
+// ("No. 5", "Fig. 2", "Wed. at noon".)
+var AmbiguousAbbreviations = map[sentiment.Language][]string{
+	sentiment.English: {
+		"no", "st", "gen", "rep", "col", "fig", "est", "al",
+		"mar", "wed", "sat", "sun",
+	},
+}
+
+// ValidateSegmenter makes sure the given
+// segmenter name is one we know about
+func ValidateSegmenter(segmenter string) error {
+	switch segmenter {
+	case "", EngineSegmenter, ServerSegmenter:
+		return nil
+	}
+
+	return fmt.Errorf("unknown sentence segmenter '%v'. Expected one of '%v' or '%v'", segmenter, EngineSegmenter, ServerSegmenter)
+}
+
+// abbreviationKind tells how an abbreviation
+// decides whether the period after it ends
+// a sentence
+type abbreviationKind int
+
+const (
+	// abbreviationTitle never ends a sentence
+	abbreviationTitle abbreviationKind = iota + 1
+
+	// abbreviationKnown ends a sentence before
+	// a capitalized word
+	abbreviationKnown
+
+	// abbreviationAmbiguous only continues a
+	// sentence when it's capitalized and
+	// followed by a lowercase word or number
+	abbreviationAmbiguous
+)
+
+// abbreviationsFor returns the set of
+// abbreviations known for a language, from
+// both the built in lists and the config,
+// with their kind.
+func abbreviationsFor(lang sentiment.Language) map[string]abbreviationKind {
+	if lang == sentiment.NoLanguage {
+		lang = sentiment.English
+	}
+
+	set := map[string]abbreviationKind{}
+	for _, abbreviation := range AmbiguousAbbreviations[lang] {
+		set[abbreviation] = abbreviationAmbiguous
+	}
+	for _, abbreviation := range Abbreviations[lang] {
+		set[abbreviation] = abbreviationKnown
+	}
+	if Config != nil {
+		for _, abbreviation := range Config.Abbreviations[lang] {
+			set[strings.TrimSuffix(strings.ToLower(abbreviation), ".")] = abbreviationKnown
+		}
+	}
+	for _, abbreviation := range TitleAbbreviations[lang] {
+		set[abbreviation] = abbreviationTitle
+	}
+
+	return set
+}
+
+// SegmentSentences splits text into sentences,
+// returning each sentence with surrounding
+// whitespace trimmed, in order. Unlike the
+// engine's splitter it won't break:
+//
+//   - after titles ("Dr. Smith"), other known
+//     abbreviations which aren't followed by a
+//     capitalized word ("5 p.m. on Monday"),
+//     or initials and initialisms followed by
+//     a lowercase word or number ("the U.S.
+//     market")
+//   - within numbers or tokens ("3.5", "a.com")
+//   - on an ellipsis which isn't followed by
+//     a capitalized word ("well… maybe")
+//
+// and closing quotes or brackets after the
+// end of a sentence stay with that sentence.
+// A blank line always ends a sentence.
+func SegmentSentences(text string, lang sentiment.Language) []string {
+	abbreviations := abbreviationsFor(lang)
+
+	sentences := []string{}
+	emit := func(s string) {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			sentences = append(sentences, s)
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+
+		if r == '\n' {
+			end := i + size
+			for end < len(text) && (text[end] == ' ' || text[end] == '\t' || text[end] == '\r') {
+				end++
+			}
+			if end < len(text) && text[end] == '\n' {
+				emit(text[start:end])
+				start = end
+			}
+			i = end
+			continue
+		}
+
+		if !isTerminator(r) {
+			i += size
+			continue
+		}
+
+		// consume the whole run of terminators
+		// ("?!", "...") and any closing quotes
+		end := i
+		for end < len(text) {
+			next, n := utf8.DecodeRuneInString(text[end:])
+			if !isTerminator(next) {
+				break
+			}
+			end += n
+		}
+		run := text[i:end]
+		for end < len(text) {
+			next, n := utf8.DecodeRuneInString(text[end:])
+			if !isCloser(next) {
+				break
+			}
+			end += n
+		}
+
+		// sentences only end before whitespace
+		// or at the end of the text
+		if end < len(text) {
+			next, _ := utf8.DecodeRuneInString(text[end:])
+			if !unicode.IsSpace(next) {
+				i = end
+				continue
+			}
+		}
+
+		if run == "." && isAbbreviation(lastToken(text[start:i]), text[end:], abbreviations) {
+			i = end
+			continue
+		}
+
+		if (strings.Contains(run, "..") || strings.ContainsRune(run, '…')) && !startsCapitalized(text[end:]) {
+			i = end
+			continue
+		}
+
+		emit(text[start:end])
+		start = end
+		i = end
+	}
+	emit(text[start:])
+
+	return sentences
+}
+
+// isTerminator returns whether a rune
+// can end a sentence
+func isTerminator(r rune) bool {
+	switch r {
+	case '.', '!', '?', '…':
+		return true
+	}
+	return false
+}
+
+// isCloser returns whether a rune closes a
+// quote or bracket, which stays with the
+// sentence before it
+func isCloser(r rune) bool {
+	switch r {
+	case '"', '\'', ')', ']', '”', '’', '»':
+		return true
+	}
+	return false
+}
+
+// lastToken returns the last whitespace
+// separated token within s, without any
+// opening quotes or brackets
+func lastToken(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.TrimLeft(fields[len(fields)-1], "\"'([“‘«")
+}
+
+// isAbbreviation returns whether the token
+// before a period is an abbreviation, given
+// the text after the period: a title ("Dr"),
+// another known abbreviation which isn't
+// followed by a capitalized word, or an
+// initial ("J", but not "I") or letters
+// separated by periods ("U.S", "e.g")
+// followed by a lowercase word or number.
+// Ambiguous abbreviations must also be
+// capitalized.
+func isAbbreviation(token, rest string, abbreviations map[string]abbreviationKind) bool {
+	if token == "" {
+		return false
+	}
+
+	switch abbreviations[strings.ToLower(token)] {
+	case abbreviationTitle:
+		return true
+	case abbreviationKnown:
+		return !startsCapitalized(rest)
+	case abbreviationAmbiguous:
+		first, _ := utf8.DecodeRuneInString(token)
+		return unicode.IsUpper(first) && startsLowercase(rest)
+	}
+
+	if r, size := utf8.DecodeRuneInString(token); size == len(token) {
+		return unicode.IsUpper(r) && token != "I" && startsLowercase(rest)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) < 2 {
+		return false
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) != 1 || !unicode.IsLetter([]rune(part)[0]) {
+			return false
+		}
+	}
+
+	return startsLowercase(rest)
+}
+
+// startsLowercase returns whether the first
+// character after any whitespace and opening
+// quotes is a lowercase letter or a digit
+func startsLowercase(s string) bool {
+	for _, r := range s {
+		if unicode.IsSpace(r) || strings.ContainsRune("\"'([“‘«", r) {
+			continue
+		}
+		return unicode.IsLower(r) || unicode.IsDigit(r)
+	}
+
+	return false
+}
+
+// startsCapitalized returns whether the first
+// letter after any whitespace and opening
+// quotes is upper case
+func startsCapitalized(s string) bool {
+	for _, r := range s {
+		if unicode.IsSpace(r) || strings.ContainsRune("\"'([“‘«", r) {
+			continue
+		}
+		return unicode.IsUpper(r)
+	}
+
+	return false
+}
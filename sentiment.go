@@ -22,6 +22,21 @@ func init() {
 
 	http.Handle("/analyze", Post(HandleSentiment))
 	http.Handle("/task", Post(HandleHookedRequest))
+	http.Handle("/task/", Get(HandleJobStatus))
+	http.Handle("/compare", Post(HandleCompare))
+	http.Handle("/transcript", Post(HandleTranscript))
+	http.Handle("/cache", Admin(Get(HandleCacheStats)))
+	http.Handle("/cache/purge", Admin(Post(HandleCachePurge)))
+	http.Handle("/dryrun", Admin(Post(HandleDryRun)))
+	http.Handle("/hooks", Admin(Get(HandleListHooks)))
+	http.Handle("/hooks/", Admin(Methods(map[string]http.HandlerFunc{
+		"GET":    HandleHook,
+		"POST":   HandleHook,
+		"PUT":    HandleHook,
+		"DELETE": HandleHook,
+	})))
+	http.Handle("/schedules", Admin(Get(HandleSchedules)))
+	http.Handle("/schedules/", Admin(Get(HandleSchedules)))
 	http.Handle("/", Get(HandleStatus))
 }
 
@@ -32,6 +47,21 @@ func main() {
 		panic(fmt.Sprintf("ERROR: error parsing configuration!\n\t%v\n", err.Error()))
 	}
 
+	jobs = NewJobQueue(Config.Jobs, Config.Callbacks)
+
+	if Config.Cache != nil {
+		cache, err = NewCache(*Config.Cache)
+		if err != nil {
+			panic(fmt.Sprintf("ERROR: error creating cache!\n\t%v\n", err.Error()))
+		}
+	}
+
+	if len(Config.Schedules) != 0 {
+		schedules = NewScheduler(Config.Schedules, Config.Callbacks)
+	}
+
+	go reloadHooksOnHangup()
+
 	log.Printf("Listening at http://127.0.0.1%v ...\n", Config.portString)
 	log.Fatal(http.ListenAndServe(Config.portString, nil))
 }
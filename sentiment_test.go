@@ -2,12 +2,34 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cdipaolo/sentiment"
 )
@@ -39,6 +61,21 @@ var (
 			}
 		]
 	}`)
+	TestThread = []byte(`{
+		"data": {
+			"ticket": {"body": "My order arrived broken and support never answered"},
+			"comments": [
+				{"id": 1, "body": "I love this store"},
+				{"id": 2, "body": "Terrible service, never again"}
+			]
+		},
+		"transcript": {
+			"segments": [
+				{"offset": {"from": 0, "to": 1.5}, "words": "What a happy day"},
+				{"offset": {"from": 1.5, "to": 3}, "words": "until it rained"}
+			]
+		}
+	}`)
 	TestTemporalArray = []byte(`[
 		{
 			"start": 0,
@@ -58,7 +95,126 @@ var (
 	]`)
 )
 
+var (
+	// TestXML is a thread of comments
+	// served as XML
+	TestXML = []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<thread id="42">
+	<title>Opening night</title>
+	<comments>
+		<comment id="1" author="ann"><body>I love this theatre</body></comment>
+		<comment id="2" author="bob"><body format="plain">The seats were <em>terrible</em></body></comment>
+	</comments>
+	<captions>
+		<caption start="0" end="2.5">What a great show</caption>
+		<caption start="2.5" end="4">Sad ending though</caption>
+	</captions>
+</thread>`)
+
+	// TestCSV is a survey export
+	TestCSV = []byte("\xef\xbb\xbfid;start;end;answer\n" +
+		"1;0;1.5;\"Great service; happy\"\n" +
+		"2;1.5;3;Bad wait times\n")
+
+	// TestRSS and TestAtom are news feeds
+	TestRSS = []byte(`<?xml version="1.0"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+	<channel>
+		<title>Reviews</title>
+		<item>
+			<title>A great launch</title>
+			<guid>review-1</guid>
+			<pubDate>Tue, 03 Mar 2026 10:00:00 +0000</pubDate>
+			<description>&lt;p&gt;Everyone was &lt;b&gt;happy&lt;/b&gt; &amp;amp; excited&lt;/p&gt;</description>
+		</item>
+		<item>
+			<title>Support is slow</title>
+			<link>https://example.com/reviews/2</link>
+			<pubDate>Wed, 4 Mar 2026 09:30:00 GMT</pubDate>
+			<content:encoded><![CDATA[<p>A bad, sad experience</p>]]></content:encoded>
+		</item>
+	</channel>
+</rss>`)
+	TestAtom = []byte(`<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Status</title>
+	<entry>
+		<title type="html">Everything is &lt;i&gt;great&lt;/i&gt;</title>
+		<link rel="self" href="https://example.com/entries/1.xml"/>
+		<link href="https://example.com/entries/1"/>
+		<updated>2026-03-05T12:00:00Z</updated>
+		<summary>All systems are good</summary>
+	</entry>
+</feed>`)
+
+	// TestSRT, TestVTT and TestWhisper are
+	// transcripts of the same call
+	TestSRT = []byte("1\r\n00:00:00,000 --> 00:00:02,500\r\n>> ANN: What a <i>great</i> show\r\n\r\n" +
+		"2\r\n00:00:02,500 --> 00:00:04,000\r\n{\\an8}BOB: Sad ending\r\nthough\r\n\r\n" +
+		"3\r\n00:00:04,000 --> 00:01:05,250\r\nI love it\r\n")
+	TestVTT = []byte(`WEBVTT - call
+
+NOTE the call was recorded
+
+intro
+00:00.000 --> 00:02.500 align:start
+<v Ann>What a <b>great</b> show</v>
+
+00:00:02.500 --> 00:00:04.000
+<v.host Bob>Sad ending
+though
+<v Ann>I love it &amp; you
+`)
+	TestWhisper = []byte(`{
+	"text": "What a great show. Sad ending though.",
+	"segments": [
+		{"start": 0, "end": 2.5, "text": " What a great show.", "speaker": "SPEAKER_00"},
+		{"start": 2.5, "end": 4.25, "text": " Sad ending though. I love it", "words": [
+			{"word": " Sad", "start": 2.5, "end": 2.8, "speaker": "SPEAKER_01"},
+			{"word": " ending", "start": 2.8, "end": 3.1, "speaker": "SPEAKER_01"},
+			{"word": " though.", "start": 3.1, "end": 3.4},
+			{"word": " I", "start": 3.5, "end": 3.6, "speaker": "SPEAKER_00"},
+			{"word": " love"},
+			{"word": " it", "start": 3.9, "end": 4.1, "speaker": "SPEAKER_00"}
+		]}
+	]
+}`)
+)
+
+var (
+	// TestCallbacks receives each successful
+	// request to the test callback handler
+	TestCallbacks = make(chan CallbackRequest, 10)
+
+	// TestAlerts receives each request
+	// to the test alert handler
+	TestAlerts = make(chan CallbackRequest, 10)
+
+	callbackFailures int32
+
+	// moodPolls counts the requests to the
+	// test mood handler, which turns sour
+	// after the first few
+	moodPolls int32
+
+	unstableAttempts int32
+	downAttempts     int32
+	etagFetches      int32
+
+	// tokensIssued counts the tokens given by the
+	// test token server, and validToken is the one
+	// the test protected handler accepts
+	tokensIssued int32
+	validToken   int32
+)
+
 func init() {
+	// referenced by the config
+	os.Setenv("COMMENT_TOKEN", "SUPER_SECRET")
+	os.Setenv("OAUTH_CLIENT_SECRET", "OAUTH_SECRET")
+	os.Setenv("SIGNING_SECRET", "HMAC_SECRET")
+	os.Setenv("ADMIN_TOKEN", "ADMIN_SECRET")
+
 	// create test handlers for hooks
 	http.HandleFunc("/test/comment/", func(r http.ResponseWriter, req *http.Request) {
 		r.Header().Add("Content-Type", "application/json")
@@ -92,9 +248,296 @@ func init() {
 		r.Write(TestTemporal)
 	})
 
+	http.HandleFunc("/test/search/", func(r http.ResponseWriter, req *http.Request) {
+		r.Header().Add("Content-Type", "application/json")
+
+		query := struct {
+			ID   string `json:"id"`
+			Term string `json:"term"`
+		}{}
+		err := json.NewDecoder(req.Body).Decode(&query)
+		if err != nil || req.Method != "POST" || req.Header.Get("Content-Type") != "application/json" {
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(`{"message": "ERROR: expected a POSTed JSON query"}`))
+			return
+		}
+
+		text, _ := json.Marshal(fmt.Sprintf("searched %v for %v", query.ID, query.Term))
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(fmt.Sprintf(`{"text": %s}`, text)))
+	})
+
+	http.HandleFunc("/test/orgs/", func(r http.ResponseWriter, req *http.Request) {
+		r.Header().Add("Content-Type", "text/plain")
+
+		// expects /test/orgs/{org}/tickets/{id}
+		segments := strings.Split(req.URL.EscapedPath(), "/")
+		if len(segments) != 6 || segments[4] != "tickets" || len(req.URL.Query()) != 1 {
+			r.WriteHeader(http.StatusNotFound)
+			r.Write([]byte(`unexpected path ` + req.URL.String()))
+			return
+		}
+
+		id, _ := url.PathUnescape(segments[5])
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(fmt.Sprintf("org=%v id=%v page=%v", segments[3], id, req.URL.Query().Get("page"))))
+	})
+
+	http.HandleFunc("/test/thread/", func(r http.ResponseWriter, req *http.Request) {
+		r.Header().Add("Content-Type", "application/json")
+		r.WriteHeader(http.StatusOK)
+		r.Write(TestThread)
+	})
+
+	http.HandleFunc("/test/flaky/", func(r http.ResponseWriter, req *http.Request) {
+		id := strings.TrimPrefix(req.URL.Path, "/test/flaky/")
+		if strings.HasPrefix(id, "bad") {
+			r.Header().Add("Content-Type", "text/plain")
+			r.WriteHeader(http.StatusOK)
+			r.Write([]byte("this is not JSON"))
+			return
+		}
+
+		r.Header().Add("Content-Type", "application/json")
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(fmt.Sprintf(`{"text": "record %v was great"}`, id)))
+	})
+
+	http.HandleFunc("/test/slow/", func(r http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		r.WriteHeader(http.StatusOK)
+		r.Write(TestPost)
+	})
+
+	http.HandleFunc("/test/unstable/", func(r http.ResponseWriter, req *http.Request) {
+		// fail the first two attempts to exercise retries
+		if atomic.AddInt32(&unstableAttempts, 1) <= 2 {
+			r.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		r.Header().Add("Content-Type", "application/json")
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(`{"text": "the upstream recovered and it was wonderful"}`))
+	})
+
+	http.HandleFunc("/test/down/", func(r http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&downAttempts, 1)
+		r.WriteHeader(http.StatusInternalServerError)
+	})
+
+	http.HandleFunc("/test/etag/", func(r http.ResponseWriter, req *http.Request) {
+		r.Header().Add("ETag", `"v1"`)
+		r.Header().Add("Cache-Control", "no-cache")
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			r.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		atomic.AddInt32(&etagFetches, 1)
+		r.Header().Add("Content-Type", "application/json")
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(`{"text": "this response never changes and that is lovely"}`))
+	})
+
+	http.HandleFunc("/test/oauth/token", func(r http.ResponseWriter, req *http.Request) {
+		r.Header().Add("Content-Type", "application/json")
+
+		id, secret, ok := req.BasicAuth()
+		if !ok || id != "sentiment-server" || secret != "OAUTH_SECRET" {
+			r.WriteHeader(http.StatusUnauthorized)
+			r.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		if req.FormValue("grant_type") != "client_credentials" || req.FormValue("scope") != "comments:read posts:read" {
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(`{"error": "invalid_request"}`))
+			return
+		}
+
+		token := atomic.AddInt32(&tokensIssued, 1)
+		atomic.StoreInt32(&validToken, token)
+
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(fmt.Sprintf(`{"access_token": "token-%v", "token_type": "Bearer", "expires_in": 3600}`, token)))
+	})
+
+	http.HandleFunc("/test/protected/", func(r http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%v", atomic.LoadInt32(&validToken)) {
+			r.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.Header().Add("Content-Type", "application/json")
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(`{"text": "only the authorized get to see this wonderful text"}`))
+	})
+
+	http.HandleFunc("/test/signed/", func(r http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		sum := sha256.Sum256(body)
+
+		signed := strings.Join([]string{
+			req.Method,
+			req.URL.RequestURI(),
+			req.Header.Get("X-Signed-At"),
+			"host:" + req.Host,
+			"content-type:" + req.Header.Get("Content-Type"),
+			hex.EncodeToString(sum[:]),
+		}, "\n")
+		mac := hmac.New(sha512.New, []byte("HMAC_SECRET"))
+		mac.Write([]byte(signed))
+
+		if req.Header.Get("X-Signature") != "sha512="+hex.EncodeToString(mac.Sum(nil)) {
+			r.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.Header().Add("Content-Type", "application/json")
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte(`{"text": "a properly signed request is a beautiful thing"}`))
+	})
+
+	http.HandleFunc("/test/callback/", func(r http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+
+		// fail the first attempt to exercise retries
+		if req.Header.Get("Fail-Once") == "true" && atomic.AddInt32(&callbackFailures, 1) == 1 {
+			r.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		r.WriteHeader(http.StatusOK)
+		TestCallbacks <- CallbackRequest{
+			Header: req.Header,
+			Body:   body,
+		}
+	})
+
+	http.HandleFunc("/test/mood/", func(r http.ResponseWriter, req *http.Request) {
+		// cacheable, since scheduled polls
+		// should skip the cache anyway
+		r.Header().Add("Content-Type", "application/json")
+		r.WriteHeader(http.StatusOK)
+
+		if atomic.AddInt32(&moodPolls, 1) <= 3 {
+			r.Write([]byte(`{"text": "good great happy"}`))
+			return
+		}
+		r.Write([]byte(`{"text": "bad sad terrible"}`))
+	})
+
+	http.HandleFunc("/test/pages/", func(r http.ResponseWriter, req *http.Request) {
+		// serves the 5 comments on a thread 2
+		// at a time, paginated by the strategy
+		// given in the path (with ?trailing=1
+		// the last page still points to an
+		// empty page after it)
+		strategy := strings.TrimPrefix(req.URL.Path, "/test/pages/")
+		query := req.URL.Query()
+		trailing := ""
+		if query.Get("trailing") != "" {
+			trailing = "&trailing=1"
+		}
+
+		start := 0
+		switch strategy {
+		case "link", "header", "page":
+			page, _ := strconv.Atoi(query.Get("page"))
+			if page > 1 {
+				start = (page - 1) * 2
+			}
+		case "offset":
+			start, _ = strconv.Atoi(query.Get("offset"))
+		case "cursor":
+			if cursor := query.Get("after"); cursor != "" {
+				start, _ = strconv.Atoi(strings.TrimPrefix(cursor, "c"))
+			}
+		}
+
+		comments := []map[string]interface{}{}
+		for i := start; i < start+2 && i < 5; i++ {
+			comments = append(comments, map[string]interface{}{"id": i + 1, "text": fmt.Sprintf("comment %v", i+1)})
+		}
+		more := start+2 < 5
+		if trailing != "" {
+			more = start < 5
+		}
+
+		body := map[string]interface{}{"comments": comments}
+		switch strategy {
+		case "link":
+			body["next"] = nil
+			if more {
+				body["next"] = fmt.Sprintf("link?page=%v%v", start/2+2, trailing)
+			}
+		case "header":
+			if more {
+				r.Header().Add("Link", fmt.Sprintf(`</test/pages/header?page=%v%v>; rel="next", </test/pages/header?page=3>; rel="last"`, start/2+2, trailing))
+			}
+		case "cursor":
+			if more {
+				body["meta"] = map[string]interface{}{"cursor": fmt.Sprintf("c%v", start+2)}
+			}
+		}
+
+		r.Header().Add("Content-Type", "application/json")
+		r.WriteHeader(http.StatusOK)
+		json.NewEncoder(r).Encode(body)
+	})
+
+	http.HandleFunc("/test/redirect/", func(r http.ResponseWriter, req *http.Request) {
+		// redirects to the host given in the path
+		host := strings.TrimPrefix(req.URL.Path, "/test/redirect/")
+		http.Redirect(r, req, "http://"+host+"/test/post/1", http.StatusFound)
+	})
+
+	http.HandleFunc("/test/pages-elsewhere/", func(r http.ResponseWriter, req *http.Request) {
+		r.Header().Add("Link", `<http://localhost:8080/test/post/1>; rel="next"`)
+		r.Write([]byte(`{"text": "first page"}`))
+	})
+
+	http.HandleFunc("/test/formats/", func(r http.ResponseWriter, req *http.Request) {
+		formats := map[string][]byte{
+			"xml":     TestXML,
+			"csv":     TestCSV,
+			"rss":     TestRSS,
+			"atom":    TestAtom,
+			"srt":     TestSRT,
+			"vtt":     TestVTT,
+			"whisper": TestWhisper,
+		}
+
+		data, ok := formats[strings.TrimPrefix(req.URL.Path, "/test/formats/")]
+		if !ok {
+			r.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		r.WriteHeader(http.StatusOK)
+		r.Write(data)
+	})
+
+	http.HandleFunc("/test/alert/", func(r http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.WriteHeader(http.StatusOK)
+
+		select {
+		case TestAlerts <- CallbackRequest{Header: req.Header, Body: body}:
+		default:
+		}
+	})
+
 	go main()
 }
 
+// CallbackRequest is a request received
+// by the test callback handler
+type CallbackRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
 // post takes a path and a json to post, performs a
 // POST request, and returns the status, the body,
 // and any errors
@@ -113,6 +556,46 @@ func post(pth string, json string) (int, []byte, error) {
 	return resp.StatusCode, body, nil
 }
 
+// upload posts the body to the server at
+// the specified path with the content type
+func upload(pth, contentType, body string) (int, []byte, error) {
+	resp, err := http.Post(Protocol+path.Join(URL, pth), contentType, bytes.NewBuffer([]byte(body)))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, data, nil
+}
+
+// admin makes a request to the server at the
+// specified path with the admin token
+func admin(method, pth, token, json string) (int, []byte, error) {
+	req, err := http.NewRequest(method, Protocol+path.Join(URL, pth), bytes.NewBuffer([]byte(json)))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, body, nil
+}
+
 // get makes a get request to the server
 // at the specified path
 func get(pth string) (int, []byte, error) {
@@ -521,6 +1004,2498 @@ func TestHookedSentimentShouldFail2(t *testing.T) {
 	}
 }
 
+// * Lexicon overrides * //
+
+func TestLexiconShouldPass1(t *testing.T) {
+	status, body, err := post("analyze", `{
+		"text": "That new level was sick! I loved it",
+		"lexicon": {
+			"sick": {"probability": 1}
+		}
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	analysis := AnalysisResponse{}
+	err = json.Unmarshal(body, &analysis)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	if len(analysis.Overridden) != 1 {
+		t.Fatalf("ERROR: exactly one word should have been overridden\n\t%+v\n", analysis.Overridden)
+	}
+
+	o := analysis.Overridden[0]
+	if o.Word != "sick!" || o.Positive != 1 {
+		t.Errorf("ERROR: overridden word should be 'sick!' with probability 1\n\t%+v\n", o)
+	}
+	if analysis.Words[o.Index].Score != 1 || analysis.Words[o.Index].Probability != 1 {
+		t.Errorf("ERROR: overridden word should be scored positive with probability 1\n\t%+v\n", analysis.Words[o.Index])
+	}
+}
+
+func TestLexiconShouldFail1(t *testing.T) {
+	status, body, err := post("analyze", `{
+		"text": "That new level was sick!",
+		"lexicon": {
+			"sick": {"probability": 1.5}
+		}
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("ERROR: status returned should be 400 BAD REQUEST\n\t%v\n", string(body))
+	}
+}
+
+// * POST /compare tests * //
+
+func TestCompareShouldPass1(t *testing.T) {
+	status, body, err := post("compare", `{
+		"a": {"text": "I love this product. It works great."},
+		"b": {"text": "I hate this product. It works great."},
+		"limit": 3
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	comparison := CompareResponse{}
+	err = json.Unmarshal(body, &comparison)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	if comparison.A == nil || comparison.B == nil {
+		t.Fatalf("ERROR: both analyses should be returned\n\t%v\n", string(body))
+	}
+	if comparison.Delta != comparison.ConfidenceB-comparison.ConfidenceA {
+		t.Errorf("ERROR: delta should be the difference in confidence\n\t%+v\n", comparison)
+	}
+	if len(comparison.Words) == 0 || len(comparison.Words) > 3 {
+		t.Errorf("ERROR: word deltas should be limited to 3 items\n\t%+v\n", comparison.Words)
+	}
+	for _, w := range comparison.Words {
+		if w.Text == "product" || w.Text == "works" {
+			t.Errorf("ERROR: words shared by both documents should not differ\n\t%+v\n", comparison.Words)
+		}
+	}
+}
+
+func TestCompareShouldPass2(t *testing.T) {
+	status, body, err := post("compare", `{
+		"a": {"recordingId": "1", "hookId": "comment"},
+		"b": {"text": "I think as a whole I have a good life"}
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	comparison := CompareResponse{}
+	err = json.Unmarshal(body, &comparison)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	_, text, _, err := GetHookResponse(TaskJSON{
+		ID:     "1",
+		HookID: "comment",
+	})
+	if err != nil {
+		t.Fatalf("ERROR: could not get hooked response!\n\t%v\n", err)
+	}
+
+	should := model.SentimentAnalysis(text, sentiment.English)
+	if len(should.Words) != len(comparison.A.Words) {
+		t.Errorf("ERROR: hooked document should be analyzed like POST /task\n\tShould be: %v\n\tReturned: %v\n", len(should.Words), len(comparison.A.Words))
+	}
+}
+
+func TestCompareShouldFail1(t *testing.T) {
+	status, body, err := post("compare", `{
+		"a": {"recordingId": "1", "hookId": "does-not-exist"},
+		"b": {"text": "I think as a whole I have a good life"}
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusInternalServerError {
+		t.Errorf("ERROR: status returned should be 500 SERVER ERROR\n\t%v\n", string(body))
+	}
+}
+
+// * Highlights * //
+
+func TestHighlightsShouldPass1(t *testing.T) {
+	text := "I love this phone. The battery is terrible. Shipping took a week. The screen is great."
+	status, body, err := post("analyze", fmt.Sprintf(`{
+		"text": "%v",
+		"highlights": 1
+	}`, text))
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	analysis := AnalysisResponse{}
+	err = json.Unmarshal(body, &analysis)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	if analysis.Highlights == nil {
+		t.Fatalf("ERROR: highlights should be returned when requested\n\t%v\n", string(body))
+	}
+	if len(analysis.Highlights.Positive) > 1 || len(analysis.Highlights.Negative) > 1 {
+		t.Errorf("ERROR: at most one highlight of each kind should be returned\n\t%+v\n", analysis.Highlights)
+	}
+
+	for _, h := range append(analysis.Highlights.Positive, analysis.Highlights.Negative...) {
+		if h.Start < 0 || text[h.Start:h.End] != h.Sentence {
+			t.Errorf("ERROR: highlight positions should locate the sentence within the text\n\t%+v\n", h)
+		}
+	}
+}
+
+func TestHighlightsShouldPass2(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "comment"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	analysis := AnalysisResponse{}
+	err = json.Unmarshal(body, &analysis)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	if analysis.Highlights != nil {
+		t.Errorf("ERROR: highlights should not be returned unless requested\n\t%+v\n", analysis.Highlights)
+	}
+}
+
+// * HTML heatmap * //
+
+func TestHeatmapShouldPass1(t *testing.T) {
+	req, err := http.NewRequest("POST", Protocol+path.Join(URL, "analyze"), bytes.NewBufferString(`{
+		"text": "I love this phone. The battery is <b>terrible</b>."
+	}`))
+	if err != nil {
+		t.Fatalf("ERROR: error building request\n\t%v\n", err)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ERROR: error reading response\n\t%v\n", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("ERROR: content type should be text/html\n\t%v\n", resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "<!DOCTYPE html>") || !strings.Contains(string(body), "background-color: #") {
+		t.Errorf("ERROR: response should be a colored HTML page\n\t%v\n", string(body))
+	}
+	if strings.Contains(string(body), "<b>") || strings.Contains(string(body), "ZgotmplZ") {
+		t.Errorf("ERROR: analyzed text and colors should be escaped safely\n\t%v\n", string(body))
+	}
+}
+
+func TestHeatmapShouldPass2(t *testing.T) {
+	// keyed series are in milliseconds, while
+	// top level arrays keep the upstream's times
+	tests := map[string]string{
+		"temporal":      "<th>start (ms)</th>",
+		"temporalArray": "<th>start</th>",
+	}
+
+	for hook, header := range tests {
+		req, err := http.NewRequest("POST", Protocol+path.Join(URL, "task"), bytes.NewBufferString(fmt.Sprintf(`{
+			"recordingId": "1",
+			"hookId": %q
+		}`, hook)))
+		if err != nil {
+			t.Fatalf("ERROR: error building request\n\t%v\n", err)
+		}
+		req.Header.Set("Accept", "text/html")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("ERROR: error trying to post\n\t%v\n", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("ERROR: error reading response\n\t%v\n", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+		}
+		if !strings.Contains(string(body), "<table>") {
+			t.Errorf("ERROR: time series should be rendered as a table\n\t%v\n", string(body))
+		}
+		if !strings.Contains(string(body), header) {
+			t.Errorf("ERROR: the %v series should be labelled with its unit\n\t%v\n", hook, string(body))
+		}
+	}
+}
+
+// * Sentence segmentation * //
+
+func TestSegmentSentencesShouldPass1(t *testing.T) {
+	tests := []struct {
+		text      string
+		sentences []string
+	}{
+		{
+			text:      "Dr. Smith moved to the U.S. in 2010. He paid 3.5 times more!",
+			sentences: []string{"Dr. Smith moved to the U.S. in 2010.", "He paid 3.5 times more!"},
+		},
+		{
+			text:      `She said "I love it." Then she left... and never came back. Really?! Yes.`,
+			sentences: []string{`She said "I love it."`, "Then she left... and never came back.", "Really?!", "Yes."},
+		},
+		{
+			text:      "Well… Maybe not. Visit example.com for e.g. pricing",
+			sentences: []string{"Well…", "Maybe not.", "Visit example.com for e.g. pricing"},
+		},
+		{
+			text:      "first paragraph\n\nsecond paragraph",
+			sentences: []string{"first paragraph", "second paragraph"},
+		},
+		{
+			text:      "The answer was no. We left right away.",
+			sentences: []string{"The answer was no.", "We left right away."},
+		},
+		{
+			text:      "We sat in the sun. It was hot.",
+			sentences: []string{"We sat in the sun.", "It was hot."},
+		},
+		{
+			text:      "I moved to the U.S. It was great.",
+			sentences: []string{"I moved to the U.S.", "It was great."},
+		},
+		{
+			text:      "See Fig. 2 and No. 5 on Wed. at noon. Then go.",
+			sentences: []string{"See Fig. 2 and No. 5 on Wed. at noon.", "Then go."},
+		},
+		{
+			text:      "Neither did I. We left early.",
+			sentences: []string{"Neither did I.", "We left early."},
+		},
+		{
+			text:      "We met at 5 p.m. Then we left at 6 p.m. on the dot.",
+			sentences: []string{"We met at 5 p.m.", "Then we left at 6 p.m. on the dot."},
+		},
+		{
+			text:      "Plan A. was dropped by Prof. Jones on Jan. 5. Plan B won.",
+			sentences: []string{"Plan A. was dropped by Prof. Jones on Jan. 5.", "Plan B won."},
+		},
+	}
+
+	for _, test := range tests {
+		sentences := SegmentSentences(test.text, sentiment.English)
+		if len(sentences) != len(test.sentences) {
+			t.Errorf("ERROR: wrong number of sentences for %q\n\tShould be: %q\n\tReturned: %q\n", test.text, test.sentences, sentences)
+			continue
+		}
+		for i := range sentences {
+			if sentences[i] != test.sentences[i] {
+				t.Errorf("ERROR: wrong sentence for %q\n\tShould be: %q\n\tReturned: %q\n", test.text, test.sentences[i], sentences[i])
+			}
+		}
+	}
+}
+
+func TestSegmentSentencesShouldPass2(t *testing.T) {
+	Config.Segmenter = ServerSegmenter
+	defer func() { Config.Segmenter = "" }()
+
+	status, body, err := post("analyze", `{
+		"text": "Dr. Smith loved the U.S. office. He hated the 2.5 hour commute."
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	analysis := AnalysisResponse{}
+	err = json.Unmarshal(body, &analysis)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	if len(analysis.Sentences) != 2 {
+		t.Fatalf("ERROR: server segmenter should find exactly two sentences\n\t%+v\n", analysis.Sentences)
+	}
+	if analysis.Sentences[1].Sentence != "He hated the 2.5 hour commute." {
+		t.Errorf("ERROR: decimals should not split sentences\n\t%+v\n", analysis.Sentences)
+	}
+}
+
+// * Async tasks * //
+
+func TestAsyncTaskShouldPass1(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "temporal",
+		"async": true
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusAccepted {
+		t.Fatalf("ERROR: status returned should be 202 ACCEPTED\n\t%v\n", string(body))
+	}
+
+	job := Job{}
+	err = json.Unmarshal(body, &job)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+	if job.ID == "" || job.Status != JobQueued {
+		t.Fatalf("ERROR: job should be queued with an id\n\t%+v\n", job)
+	}
+
+	for i := 0; i < 100 && job.Status != JobDone && job.Status != JobFailed; i++ {
+		time.Sleep(10 * time.Millisecond)
+
+		status, body, err = get("task/" + job.ID)
+		if err != nil {
+			t.Fatalf("ERROR: error trying to get\n\t%v\n", err)
+		}
+		if status != http.StatusOK {
+			t.Fatalf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+		}
+
+		err = json.Unmarshal(body, &job)
+		if err != nil {
+			t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+		}
+	}
+
+	if job.Status != JobDone {
+		t.Fatalf("ERROR: job should have finished successfully\n\t%v\n", string(body))
+	}
+
+	analysis := TimeSeriesResponse{}
+	err = json.Unmarshal(job.Result, &analysis)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling job result\n\t%v\n", err)
+	}
+	if len(analysis.Series) != 3 || analysis.Metadata == nil {
+		t.Errorf("ERROR: job result should be the same as a synchronous task\n\t%v\n", string(job.Result))
+	}
+}
+
+func TestAsyncTaskShouldFail1(t *testing.T) {
+	status, body, err := get("task/does-not-exist")
+	if err != nil {
+		t.Errorf("ERROR: error trying to get\n\t%v\n", err)
+	}
+	if status != http.StatusNotFound {
+		t.Errorf("ERROR: status returned should be 404 NOT FOUND\n\t%v\n", string(body))
+	}
+}
+
+// * Callbacks * //
+
+func TestCallbackShouldPass1(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "comment",
+		"callback": {
+			"url": "http://localhost:8080/test/callback/",
+			"headers": {"Fail-Once": ["true"]}
+		}
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusAccepted {
+		t.Fatalf("ERROR: status returned should be 202 ACCEPTED\n\t%v\n", string(body))
+	}
+
+	job := Job{}
+	err = json.Unmarshal(body, &job)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	var callback CallbackRequest
+	select {
+	case callback = <-TestCallbacks:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ERROR: callback was never delivered\n")
+	}
+
+	if callback.Header.Get("X-Sentiment-Job") != job.ID || callback.Header.Get("X-Sentiment-Status") != JobDone {
+		t.Errorf("ERROR: callback should identify the finished job\n\t%v\n", callback.Header)
+	}
+	if callback.Header.Get(SignatureHeader) != Sign("CALLBACK_SECRET", callback.Body) {
+		t.Errorf("ERROR: callback body should be signed with the configured secret\n\t%v\n", callback.Header)
+	}
+
+	analysis := AnalysisResponse{}
+	err = json.Unmarshal(callback.Body, &analysis)
+	if err != nil || analysis.Analysis == nil || len(analysis.Words) == 0 {
+		t.Errorf("ERROR: callback body should be the task analysis\n\t%v\n", string(callback.Body))
+	}
+
+	// the delivery is recorded just after the
+	// callback responds
+	for i := 0; i < 100; i++ {
+		job, _ = jobs.Get(job.ID)
+		if job.Delivery.Status != DeliveryPending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if job.Delivery.Status != DeliveryDelivered || len(job.Delivery.Attempts) != 2 {
+		t.Errorf("ERROR: delivery should succeed on the second attempt\n\t%+v\n", job.Delivery)
+	}
+	if job.Delivery.Attempts[0].StatusCode != http.StatusInternalServerError {
+		t.Errorf("ERROR: the failed attempt should be recorded\n\t%+v\n", job.Delivery.Attempts)
+	}
+}
+
+func TestCallbackShouldFail1(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"callback": {"url": "/not/absolute"}
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("ERROR: status returned should be 400 BAD REQUEST\n\t%v\n", string(body))
+	}
+}
+
+func TestCallbackShouldFail2(t *testing.T) {
+	// the configured callback policy only
+	// allows localhost
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"callback": {"url": "http://127.0.0.1:8080/test/callback/"}
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("ERROR: status returned should be 400 BAD REQUEST\n\t%v\n", string(body))
+	}
+
+	// without a policy callbacks can't
+	// reach loopback addresses at all
+	configured := Config.Callbacks.Outbound
+	Config.Callbacks.Outbound = OutboundPolicy{}
+	defer func() { Config.Callbacks.Outbound = configured }()
+
+	for _, u := range []string{"http://127.0.0.1/test/callback/", "http://[::1]:8080/", "http://169.254.169.254/latest/meta-data/"} {
+		callback := Callback{URL: u}
+		if err := callback.Validate(); err == nil {
+			t.Errorf("ERROR: the callback to %v should be refused\n", u)
+		}
+	}
+
+	// hosts resolving to loopback are
+	// refused when they're delivered
+	c := CallbacksConfig{MaxAttempts: 2, Backoff: Duration(time.Millisecond)}.withDefaults()
+	callback := Callback{URL: "http://localhost:8080/test/callback/"}
+	var (
+		attempts []DeliveryAttempt
+		clients  []*http.Client
+	)
+	for i := 0; i < 2; i++ {
+		retryDelivery(c, func(client *http.Client) (int, error) {
+			clients = append(clients, client)
+			return postCallback(client, callback, []byte(`{}`), "", nil)
+		}, func(attempt int, record DeliveryAttempt, status string) {
+			attempts = append(attempts, record)
+		})
+	}
+
+	if len(attempts) != 4 || !strings.Contains(attempts[0].Error, "not allowed") {
+		t.Errorf("ERROR: the callback should be blocked when connecting\n\t%+v\n", attempts)
+	}
+
+	// deliveries share the config's client
+	for _, client := range clients {
+		if client != c.client {
+			t.Errorf("ERROR: every delivery should reuse the callback client\n")
+			break
+		}
+	}
+}
+
+// * Templated hook bodies * //
+
+func TestHookBodyShouldPass1(t *testing.T) {
+	j := TaskJSON{
+		ID:     `7 "quoted"`,
+		HookID: "search",
+		Params: map[string]string{"term": "great things"},
+	}
+
+	_, text, _, err := GetHookResponse(j)
+	if err != nil {
+		t.Fatalf("ERROR: could not get hooked response!\n\t%v\n", err)
+	}
+
+	if text != `searched 7 "quoted" for great things` {
+		t.Errorf("ERROR: hook should be POSTed the templated body\n\t%v\n", text)
+	}
+}
+
+func TestHookBodyShouldFail1(t *testing.T) {
+	_, _, _, err := GetHookResponse(TaskJSON{
+		ID:     "7",
+		HookID: "search",
+	})
+	if err == nil {
+		t.Errorf("ERROR: hook body template should fail without the params it uses\n")
+	}
+}
+
+// * Named hook URL params * //
+
+func TestHookParamsShouldPass1(t *testing.T) {
+	_, text, _, err := GetHookResponse(TaskJSON{
+		ID:     "17",
+		HookID: "ticket",
+		Params: map[string]string{"org": "acme", "page": "3"},
+	})
+	if err != nil {
+		t.Fatalf("ERROR: could not get hooked response!\n\t%v\n", err)
+	}
+
+	if text != "org=acme id=17 page=3" {
+		t.Errorf("ERROR: params should be formatted into the hook URL\n\t%v\n", text)
+	}
+}
+
+// ids with URL syntax shouldn't be able
+// to change the rest of the upstream URL
+func TestHookParamsShouldPass2(t *testing.T) {
+	_, text, _, err := GetHookResponse(TaskJSON{
+		ID:     "1/admin?page=2&x=1#frag",
+		HookID: "ticket",
+		Params: map[string]string{"org": "acme"},
+	})
+	if err != nil {
+		t.Fatalf("ERROR: could not get hooked response!\n\t%v\n", err)
+	}
+
+	if text != "org=acme id=1/admin?page=2&x=1#frag page=1" {
+		t.Errorf("ERROR: id should be escaped into a single path segment and page should default to 1\n\t%v\n", text)
+	}
+}
+
+func TestHookParamsShouldFail1(t *testing.T) {
+	tests := []map[string]string{
+		{},
+		{"org": "ACME"},
+		{"org": "acme", "page": "two"},
+	}
+
+	for _, params := range tests {
+		_, _, _, err := GetHookResponse(TaskJSON{
+			ID:     "17",
+			HookID: "ticket",
+			Params: params,
+		})
+		if err == nil {
+			t.Errorf("ERROR: params should fail validation\n\t%v\n", params)
+		}
+	}
+}
+
+// * JSONPath keys * //
+
+func TestJSONPathShouldPass1(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal(TestThread, &doc)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling test document\n\t%v\n", err)
+	}
+
+	tests := []struct {
+		expr    string
+		matches []interface{}
+	}{
+		{"data", []interface{}{doc.(map[string]interface{})["data"]}},
+		{"$.data.ticket.body", []interface{}{"My order arrived broken and support never answered"}},
+		{"$['data']['comments'][-1].id", []interface{}{2.0}},
+		{"$.data.comments[*].body", []interface{}{"I love this store", "Terrible service, never again"}},
+		{"$..from", []interface{}{0.0, 1.5}},
+		{"$.data.missing[0]", []interface{}{}},
+	}
+
+	for _, test := range tests {
+		path, err := CompileJSONPath(test.expr)
+		if err != nil {
+			t.Errorf("ERROR: could not compile %v\n\t%v\n", test.expr, err)
+			continue
+		}
+
+		matches := path.Find(doc)
+		if fmt.Sprint(matches) != fmt.Sprint(test.matches) {
+			t.Errorf("ERROR: wrong matches for %v\n\tShould be: %v\n\tReturned: %v\n", test.expr, test.matches, matches)
+		}
+	}
+}
+
+func TestJSONPathShouldFail1(t *testing.T) {
+	for _, expr := range []string{"$.", "$[0", "$[abc]", "$..", "$data"} {
+		_, err := CompileJSONPath(expr)
+		if err == nil {
+			t.Errorf("ERROR: %v should not compile\n", expr)
+		}
+	}
+}
+
+func TestJSONPathHookShouldPass1(t *testing.T) {
+	_, text, _, err := GetHookResponse(TaskJSON{
+		ID:     "1",
+		HookID: "nested",
+	})
+	if err != nil {
+		t.Fatalf("ERROR: could not get hooked response!\n\t%v\n", err)
+	}
+
+	if text != "My order arrived broken and support never answered" {
+		t.Errorf("ERROR: nested key should be extracted\n\t%v\n", text)
+	}
+}
+
+func TestJSONPathHookShouldPass2(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "nestedComments"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	analysis := AnalysisResponse{}
+	err = json.Unmarshal(body, &analysis)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	should := model.SentimentAnalysis("I love this store Terrible service, never again", sentiment.English)
+	if len(analysis.Words) != len(should.Words) {
+		t.Errorf("ERROR: matches should be joined into one document\n\t%+v\n", analysis.Words)
+	}
+	if len(analysis.Matches) != 2 || len(analysis.Matches[0].Words) != 4 {
+		t.Errorf("ERROR: each match should be analyzed separately\n\t%+v\n", analysis.Matches)
+	}
+}
+
+func TestJSONPathHookShouldPass3(t *testing.T) {
+	ts, text, _, err := GetHookResponse(TaskJSON{
+		ID:     "1",
+		HookID: "nestedTemporal",
+	})
+	if err != nil {
+		t.Fatalf("ERROR: could not get hooked response!\n\t%v\n", err)
+	}
+
+	if len(ts) != 2 {
+		t.Fatalf("ERROR: each segment should be a time series entry\n\t%+v\n", ts)
+	}
+	if ts[1].Start != 1500 || ts[1].End != 3000 || ts[1].Text != "until it rained" {
+		t.Errorf("ERROR: time fields should be found within each entry\n\t%+v\n", ts[1])
+	}
+	if text != TurnTimeSeriesIntoText(ts) {
+		t.Errorf("ERROR: text should be the joined time series text\n\t%v\n", text)
+	}
+}
+
+// * Collection hooks * //
+
+func TestCollectionShouldPass1(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "thread"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	collection := CollectionResponse{}
+	err = json.Unmarshal(body, &collection)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	if len(collection.Items) != 2 {
+		t.Fatalf("ERROR: each comment should be its own item\n\t%v\n", string(body))
+	}
+	if collection.Items[0].ID != 1.0 || collection.Items[1].ID != 2.0 {
+		t.Errorf("ERROR: item ids should be preserved in order\n\t%+v\n", collection.Items)
+	}
+
+	positive := 0
+	total := 0.0
+	for _, item := range collection.Items {
+		should := model.SentimentAnalysis(item.Text, sentiment.English)
+		if item.Analysis == nil || item.Analysis.Score != should.Score {
+			t.Errorf("ERROR: each item should be analyzed on its own\n\t%+v\n", item)
+			continue
+		}
+		if should.Score == 1 {
+			positive++
+		}
+		total += Confidence(item.Analysis.Words)
+	}
+
+	a := collection.Aggregate
+	if a.Count != 2 || a.Positive != positive || a.Negative != 2-positive {
+		t.Errorf("ERROR: aggregate should count the item labels\n\t%+v\n", a)
+	}
+	if a.MeanConfidence != total/2 {
+		t.Errorf("ERROR: aggregate should hold the mean item confidence\n\t%+v\n", a)
+	}
+}
+
+// * Batch tasks * //
+
+func TestBatchShouldPass1(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingIds": ["1", "bad-2", "3", "4", "bad-5"],
+		"hookId": "flaky"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	batch := struct {
+		Results []struct {
+			ID     string            `json:"recordingId"`
+			Result *AnalysisResponse `json:"result"`
+			Error  json.RawMessage   `json:"error"`
+		} `json:"results"`
+		Succeeded int `json:"succeeded"`
+		Failed    int `json:"failed"`
+	}{}
+	err = json.Unmarshal(body, &batch)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n", err)
+	}
+
+	if batch.Succeeded != 3 || batch.Failed != 2 || len(batch.Results) != 5 {
+		t.Fatalf("ERROR: 3 ids should succeed and 2 should fail\n\t%v\n", string(body))
+	}
+
+	for i, id := range []string{"1", "bad-2", "3", "4", "bad-5"} {
+		result := batch.Results[i]
+		if result.ID != id {
+			t.Errorf("ERROR: results should be in the order given\n\tShould be: %v\n\tReturned: %v\n", id, result.ID)
+		}
+
+		if strings.HasPrefix(id, "bad") {
+			if result.Error == nil || result.Result != nil {
+				t.Errorf("ERROR: %v should have failed\n\t%+v\n", id, result)
+			}
+			continue
+		}
+
+		if result.Result == nil || len(result.Result.Words) != 4 {
+			t.Errorf("ERROR: %v should have been analyzed\n\t%+v\n", id, result)
+		}
+	}
+}
+
+func TestBatchShouldPass2(t *testing.T) {
+	req, err := http.NewRequest("POST", Protocol+path.Join(URL, "task"), bytes.NewBufferString(`{
+		"recordingIds": ["1", "2", "bad-3"],
+		"hookId": "flaky"
+	}`))
+	if err != nil {
+		t.Fatalf("ERROR: error building request\n\t%v\n", err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("ERROR: content type should be application/x-ndjson\n\t%v\n", resp.Header.Get("Content-Type"))
+	}
+
+	seen := map[string]bool{}
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		result := BatchResult{}
+		err = decoder.Decode(&result)
+		if err != nil {
+			t.Fatalf("ERROR: error decoding streamed result\n\t%v\n", err)
+		}
+
+		seen[result.ID] = true
+		if (result.Error == nil) == strings.HasPrefix(result.ID, "bad") {
+			t.Errorf("ERROR: only bad ids should have errors\n\t%+v\n", result)
+		}
+	}
+
+	if len(seen) != 3 {
+		t.Errorf("ERROR: every id should be streamed once\n\t%v\n", seen)
+	}
+}
+
+func TestBatchShouldFail1(t *testing.T) {
+	Config.Batches.MaxSize = 2
+	defer func() { Config.Batches.MaxSize = 0 }()
+
+	status, body, err := post("task", `{
+		"recordingIds": ["1", "2", "3"],
+		"hookId": "flaky"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusBadRequest || !strings.Contains(string(body), `"maxSize": 2`) {
+		t.Errorf("ERROR: status returned should be 400 BAD REQUEST\n\t%v\n", string(body))
+	}
+
+	_, body, err = post("task", `{
+		"recordingIds": ["1", "2"],
+		"hookId": "noSuchBatchHook"
+	}`)
+	if err != nil || strings.Count(string(body), "noSuchBatchHook") < 2 {
+		t.Errorf("ERROR: every id should fail for an unknown hook\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	limitersLock.Lock()
+	_, ok := limiters["noSuchBatchHook"]
+	limitersLock.Unlock()
+	if ok {
+		t.Errorf("ERROR: unknown hooks shouldn't be given a limiter\n")
+	}
+}
+
+// * Hook clients * //
+
+func TestHookClientShouldFail1(t *testing.T) {
+	tests := []struct {
+		hook           string
+		status         int
+		upstreamStatus int
+	}{
+		{"unauthorized", http.StatusBadGateway, http.StatusUnauthorized},
+		{"slow", http.StatusGatewayTimeout, 0},
+		{"small", http.StatusBadGateway, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		status, body, err := post("task", fmt.Sprintf(`{
+			"recordingId": "1",
+			"hookId": "%v"
+		}`, test.hook))
+		if err != nil {
+			t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+		}
+		if status != test.status {
+			t.Errorf("ERROR: status returned for hook %v should be %v\n\t%v\n", test.hook, test.status, string(body))
+		}
+
+		failure := struct {
+			Error HookError `json:"error"`
+		}{}
+		err = json.Unmarshal(body, &failure)
+		if err != nil {
+			t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n\t%v\n", err, string(body))
+		}
+
+		if failure.Error.Hook != test.hook || failure.Error.UpstreamStatus != test.upstreamStatus {
+			t.Errorf("ERROR: upstream failure for hook %v should be described\n\t%v\n", test.hook, string(body))
+		}
+	}
+}
+
+// * Retries and breakers * //
+
+func TestRetryShouldPass1(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "unstable"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK after retries\n\t%v\n", string(body))
+	}
+	if attempts := atomic.LoadInt32(&unstableAttempts); attempts != 3 {
+		t.Errorf("ERROR: request should have been attempted 3 times\n\t%v\n", attempts)
+	}
+}
+
+func TestRetryShouldFail1(t *testing.T) {
+	policy := RetryPolicy{}.withDefaults()
+	tests := []struct {
+		policy  RetryPolicy
+		method  string
+		err     error
+		retried bool
+	}{
+		{policy, "GET", &HookError{transport: true}, true},
+		{policy, "GET", &HookError{UpstreamStatus: http.StatusServiceUnavailable}, true},
+		{policy, "GET", &HookError{UpstreamStatus: http.StatusNotFound}, false},
+
+		// local failures fail the same way again
+		{policy, "GET", &HookError{Message: "ERROR: could not sign HOOK request"}, false},
+		{policy, "GET", errors.New("body can't be rewound"), false},
+		{policy, "GET", &HookError{transport: true, Blocked: true}, false},
+
+		// non-idempotent methods need an opt in
+		{policy, "POST", &HookError{transport: true}, false},
+		{RetryPolicy{RetryUnsafe: true}.withDefaults(), "POST", &HookError{transport: true}, true},
+	}
+
+	for _, test := range tests {
+		if retried := test.policy.retries(test.method, test.err); retried != test.retried {
+			t.Errorf("ERROR: %v %v should be retried: %v\n", test.method, test.err, test.retried)
+		}
+	}
+
+	hook := Hook{URL: "http://127.0.0.1:8080/test/post/%v", Retry: &RetryPolicy{RetryOn: []int{404}}}
+	err := hook.Prepare()
+	if err == nil {
+		t.Errorf("ERROR: only 429 and 5xx statuses should be retried\n")
+	}
+}
+
+func TestBreakerShouldFail1(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		status, body, err := post("task", `{
+			"recordingId": "1",
+			"hookId": "down"
+		}`)
+		if err != nil {
+			t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+		}
+		if status != http.StatusBadGateway {
+			t.Errorf("ERROR: status returned should be 502 while the breaker is closed\n\t%v\n", string(body))
+		}
+	}
+
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "down"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("ERROR: status returned should be 503 once the breaker opens\n\t%v\n", string(body))
+	}
+	if attempts := atomic.LoadInt32(&downAttempts); attempts != 2 {
+		t.Errorf("ERROR: an open breaker should not reach the upstream\n\t%v\n", attempts)
+	}
+
+	failure := struct {
+		Error HookError `json:"error"`
+	}{}
+	err = json.Unmarshal(body, &failure)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n\t%v\n", err, string(body))
+	}
+	if failure.Error.Breaker == nil || failure.Error.Breaker.State != BreakerOpen {
+		t.Errorf("ERROR: the breaker state should be given\n\t%v\n", string(body))
+	}
+
+	resp, err := http.Get("http://127.0.0.1:8080")
+	if err != nil {
+		t.Fatalf("ERROR: error trying to get status\n\t%v\n", err)
+	}
+	defer resp.Body.Close()
+
+	health := struct {
+		Breakers map[string]BreakerState `json:"breakers"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling status\n\t%v\n", err)
+	}
+	if health.Breakers["down"].State != BreakerOpen {
+		t.Errorf("ERROR: status should show the open breaker\n\t%+v\n", health.Breakers)
+	}
+}
+
+// * Cache * //
+
+func TestCacheShouldPass1(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		status, body, err := post("task", `{
+			"recordingId": "1",
+			"hookId": "etag"
+		}`)
+		if err != nil {
+			t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+		}
+		if status != http.StatusOK {
+			t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+		}
+	}
+
+	if fetches := atomic.LoadInt32(&etagFetches); fetches != 1 {
+		t.Errorf("ERROR: revalidated responses shouldn't be fetched again\n\t%v\n", fetches)
+	}
+
+	stats := cache.Stats()
+	if stats[FetchCache].Revalidated < 2 {
+		t.Errorf("ERROR: stale responses should be revalidated\n\t%+v\n", stats)
+	}
+	if stats[AnalysisCache].Hits < 2 {
+		t.Errorf("ERROR: identical text should be analyzed from the cache\n\t%+v\n", stats)
+	}
+
+	// the cache is only managed
+	// through the admin API
+	for _, pth := range []string{"cache", "cache/purge"} {
+		method := "GET"
+		if pth == "cache/purge" {
+			method = "POST"
+		}
+
+		status, body, err := admin(method, pth, "wrong", `{"hookId": "etag"}`)
+		if err != nil || status != http.StatusUnauthorized {
+			t.Errorf("ERROR: status returned should be 401 UNAUTHORIZED\n\t%v\n\t%v\n", err, string(body))
+		}
+	}
+	if status, body, err := post("cache/purge", `{"hookId": "etag"}`); err != nil || status != http.StatusUnauthorized {
+		t.Errorf("ERROR: status returned should be 401 UNAUTHORIZED\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	status, body, err := admin("POST", "cache/purge", "ADMIN_SECRET", `{"hookId": "etag"}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK || string(body) != `{"purged": 1}` {
+		t.Errorf("ERROR: the hook's fetch should be purged\n\t%v\n", string(body))
+	}
+
+	_, _, err = post("task", `{
+		"recordingId": "1",
+		"hookId": "etag"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if fetches := atomic.LoadInt32(&etagFetches); fetches != 2 {
+		t.Errorf("ERROR: purged responses should be fetched again\n\t%v\n", fetches)
+	}
+}
+
+func TestCacheShouldPass2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentiment-cache")
+	if err != nil {
+		t.Fatalf("ERROR: error making temporary directory\n\t%v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewCache(CacheConfig{Size: 1, Dir: dir})
+	if err != nil {
+		t.Fatalf("ERROR: error making cache\n\t%v\n", err)
+	}
+
+	c.Set(&CacheEntry{Key: "a", Value: []byte("first"), Expires: time.Now().Add(time.Minute)})
+	c.Set(&CacheEntry{Key: "b", Value: []byte("second"), Expires: time.Now().Add(time.Minute)})
+	c.Set(&CacheEntry{Key: "c", Value: []byte("expired"), Expires: time.Now().Add(-time.Minute)})
+
+	if c.Len() != 1 {
+		t.Errorf("ERROR: the cache should only hold 1 entry in memory\n\t%v\n", c.Len())
+	}
+
+	entry, ok := c.Get("a")
+	if !ok || string(entry.Value) != "first" {
+		t.Errorf("ERROR: evicted entries should be read from disk\n\t%+v\n", entry)
+	}
+
+	_, ok = c.Get("c")
+	if ok {
+		t.Errorf("ERROR: expired entries without validators shouldn't be returned\n")
+	}
+
+	restarted, err := NewCache(CacheConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("ERROR: error making cache\n\t%v\n", err)
+	}
+	_, ok = restarted.Get("b")
+	if !ok {
+		t.Errorf("ERROR: entries on disk should outlive the cache\n")
+	}
+
+	if purged := restarted.Purge(""); purged != 2 {
+		t.Errorf("ERROR: purging should remove every entry\n\t%v\n", purged)
+	}
+}
+
+func TestCacheShouldPass3(t *testing.T) {
+	inID := fetchKey("comments", TaskJSON{ID: "1?page=2"})
+	inParams := fetchKey("comments", TaskJSON{ID: "1", Params: map[string]string{"page": "2"}})
+	if inID == inParams {
+		t.Errorf("ERROR: an id holding params should have its own cache key\n\t%v\n", inID)
+	}
+
+	if fetchKey("comments", TaskJSON{ID: "1"}) != FetchCache+"/comments/1" {
+		t.Errorf("ERROR: plain ids should be kept as they are\n\t%v\n", fetchKey("comments", TaskJSON{ID: "1"}))
+	}
+}
+
+// * Secrets * //
+
+func TestSecretsShouldPass1(t *testing.T) {
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatalf("ERROR: error making temporary file\n\t%v\n", err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("from-a-file\n"))
+	f.Close()
+
+	os.Setenv("SECRET_ORG", "acme")
+
+	hook := Hook{
+		URL: "http://127.0.0.1:8080/orgs/${env:SECRET_ORG}/tickets/{id}?key=${file:" + f.Name() + "}",
+		Headers: map[string][]string{
+			"Auth": {"Bearer ${file:" + f.Name() + "}"},
+		},
+	}
+	err = hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: error preparing hook\n\t%v\n", err)
+	}
+
+	req, err := hook.NewRequest(TaskJSON{ID: "${env:SECRET_ORG}"})
+	if err != nil {
+		t.Fatalf("ERROR: error building request\n\t%v\n", err)
+	}
+
+	expected := "http://127.0.0.1:8080/orgs/acme/tickets/$%7Benv:SECRET_ORG%7D?key=from-a-file"
+	if req.URL.String() != expected {
+		t.Errorf("ERROR: secrets should be interpolated into the URL (but not the id)\n\t%v\n\t%v\n", req.URL.String(), expected)
+	}
+	if req.Header.Get("Auth") != "Bearer from-a-file" {
+		t.Errorf("ERROR: secrets should be interpolated into headers\n\t%v\n", req.Header.Get("Auth"))
+	}
+}
+
+func TestSecretsShouldPass2(t *testing.T) {
+	defer useTemporaryHooksFile(t)()
+
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatalf("ERROR: error making temporary file\n\t%v\n", err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("first-secret\n"))
+	f.Close()
+
+	reference := "${file:" + f.Name() + "}"
+	Config.Admin.Secrets = append(Config.Admin.Secrets, reference)
+	defer func() { Config.Admin.Secrets = Config.Admin.Secrets[:len(Config.Admin.Secrets)-1] }()
+
+	status, body, err := admin("POST", "hooks/rotated", "ADMIN_SECRET", fmt.Sprintf(`{"url": "http://127.0.0.1:8080/test/post/%%v", "headers": {"Auth": [%q]}}`, reference))
+	if err != nil || status != http.StatusCreated {
+		t.Fatalf("ERROR: the hook should be created\n\t%v\n", string(body))
+	}
+	defer admin("DELETE", "hooks/rotated", "ADMIN_SECRET", "")
+
+	// rotated files are picked up on reload
+	ioutil.WriteFile(f.Name(), []byte("second-secret\n"), 0600)
+	err = ReloadHooks()
+	if err != nil {
+		t.Fatalf("ERROR: error reloading hooks\n\t%v\n", err)
+	}
+	if secret := CurrentHooks()["rotated"].secrets[reference]; secret != "second-secret" {
+		t.Errorf("ERROR: the rotated secret should be resolved again\n\t%v\n", secret)
+	}
+
+	// a missing secret keeps the hooks as they were
+	os.Remove(f.Name())
+	err = ReloadHooks()
+	if err == nil {
+		t.Errorf("ERROR: reloading with a missing secret should fail\n")
+	}
+	if secret := CurrentHooks()["rotated"].secrets[reference]; secret != "second-secret" {
+		t.Errorf("ERROR: a failed reload should keep the current hooks\n\t%v\n", secret)
+	}
+}
+
+func TestSecretsShouldFail1(t *testing.T) {
+	os.Unsetenv("MISSING_SECRET")
+
+	hooks := []Hook{
+		{URL: "http://127.0.0.1:8080/%v?token=${env:MISSING_SECRET}"},
+		{URL: "http://127.0.0.1:8080/%v", Headers: map[string][]string{"Auth": {"${file:/does/not/exist}"}}},
+		{URL: "http://127.0.0.1:8080/%v", Headers: map[string][]string{"Auth": {"${vault:token}"}}},
+	}
+
+	for _, hook := range hooks {
+		err := hook.Prepare()
+		if err == nil {
+			t.Errorf("ERROR: missing secrets should fail the hook\n\t%+v\n", hook)
+		}
+	}
+}
+
+// * OAuth * //
+
+func TestOAuthShouldPass1(t *testing.T) {
+	for i, id := range []string{"1", "2", "3"} {
+		// revoke the token before the last request
+		// so it has to be refreshed
+		if i == 2 {
+			atomic.StoreInt32(&validToken, 0)
+		}
+
+		status, body, err := post("task", fmt.Sprintf(`{
+			"recordingId": "%v",
+			"hookId": "oauth"
+		}`, id))
+		if err != nil {
+			t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+		}
+		if status != http.StatusOK {
+			t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+		}
+
+		expected := int32(1)
+		if i == 2 {
+			expected = 2
+		}
+		if issued := atomic.LoadInt32(&tokensIssued); issued != expected {
+			t.Errorf("ERROR: %v tokens should have been issued by request %v\n\t%v\n", expected, i+1, issued)
+		}
+	}
+}
+
+func TestOAuthShouldFail1(t *testing.T) {
+	hooks := []Hook{
+		{URL: "http://127.0.0.1:8080/%v", OAuth: &OAuthConfig{TokenURL: "/token", ClientID: "a", ClientSecret: "b"}},
+		{URL: "http://127.0.0.1:8080/%v", OAuth: &OAuthConfig{TokenURL: "http://127.0.0.1:8080/token", ClientID: "a"}},
+
+		// the token url must be in the allowlist
+		{
+			URL:      "http://127.0.0.1:8080/%v",
+			Outbound: &OutboundPolicy{Hosts: []string{"127.0.0.1"}},
+			OAuth:    &OAuthConfig{TokenURL: "https://auth.example.net/token", ClientID: "a", ClientSecret: "b"},
+		},
+	}
+
+	for _, hook := range hooks {
+		err := hook.Prepare()
+		if err == nil {
+			t.Errorf("ERROR: invalid oauth configs should fail the hook\n\t%+v\n", hook.OAuth)
+		}
+	}
+}
+
+// * Signing and mTLS * //
+
+func TestSigningShouldPass1(t *testing.T) {
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "signed"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: status returned should be 200 OK for a signed request\n\t%v\n", string(body))
+	}
+}
+
+func TestSigningShouldFail1(t *testing.T) {
+	hooks := []Hook{
+		{URL: "http://127.0.0.1:8080/%v", Signing: &SigningConfig{Secret: "a", Algorithm: "md5"}},
+		{URL: "http://127.0.0.1:8080/%v", Signing: &SigningConfig{}},
+	}
+
+	for _, hook := range hooks {
+		err := hook.Prepare()
+		if err == nil {
+			t.Errorf("ERROR: invalid signing configs should fail the hook\n\t%+v\n", hook.Signing)
+		}
+	}
+}
+
+// writeCertificate makes a certificate signed by the
+// parent (or self-signed without one), writing the
+// certificate and key as PEM files in dir
+func writeCertificate(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ERROR: error generating key\n\t%v\n", err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("ERROR: error creating certificate\n\t%v\n", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("ERROR: error marshalling key\n\t%v\n", err)
+	}
+
+	ioutil.WriteFile(path.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(path.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return cert, key
+}
+
+func TestMutualTLSShouldPass1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sentiment-tls")
+	if err != nil {
+		t.Fatalf("ERROR: error making temporary directory\n\t%v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCertificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	serverCert, serverKey := writeCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "upstream"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "sentiment-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(r http.ResponseWriter, req *http.Request) {
+		r.WriteHeader(http.StatusOK)
+		r.Write([]byte("hello from " + req.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	hook := Hook{
+		URL:      server.URL + "/%v",
+		Outbound: &OutboundPolicy{Networks: []string{"127.0.0.1/32"}},
+		TLS: &TLSConfig{
+			CertFile: path.Join(dir, "client.pem"),
+			KeyFile:  path.Join(dir, "client.key"),
+			CAFile:   path.Join(dir, "ca.pem"),
+		},
+	}
+	err = hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: error preparing hook\n\t%v\n", err)
+	}
+
+	req, err := hook.NewRequest(TaskJSON{ID: "1"})
+	if err != nil {
+		t.Fatalf("ERROR: error building request\n\t%v\n", err)
+	}
+	resp, err := hook.Do("mtls", req)
+	if err != nil {
+		t.Fatalf("ERROR: error making mutual TLS request\n\t%v\n", err)
+	}
+	if string(resp.Body) != "hello from sentiment-server" {
+		t.Errorf("ERROR: the client certificate should be presented\n\t%v\n", string(resp.Body))
+	}
+
+	// without a client certificate the
+	// upstream should refuse the request
+	hook.TLS = &TLSConfig{CAFile: path.Join(dir, "ca.pem")}
+	err = hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: error preparing hook\n\t%v\n", err)
+	}
+
+	req, _ = hook.NewRequest(TaskJSON{ID: "1"})
+	_, err = hook.Do("mtls", req)
+	if err == nil {
+		t.Errorf("ERROR: requests without a client certificate should fail\n")
+	}
+}
+
+func TestMutualTLSShouldFail1(t *testing.T) {
+	configs := []*TLSConfig{
+		{CertFile: "/does/not/exist.pem"},
+		{CertFile: "/does/not/exist.pem", KeyFile: "/does/not/exist.key"},
+		{CAFile: "/does/not/exist.pem"},
+	}
+
+	for _, config := range configs {
+		hook := Hook{URL: "https://127.0.0.1/%v", TLS: config}
+		err := hook.Prepare()
+		if err == nil {
+			t.Errorf("ERROR: invalid tls configs should fail the hook\n\t%+v\n", config)
+		}
+	}
+}
+
+// * Outbound policy * //
+
+func TestOutboundShouldFail1(t *testing.T) {
+	tests := []struct {
+		hook Hook
+		id   string
+	}{
+		// private, link-local and metadata IPs
+		// are blocked unless allowed
+		{Hook{URL: "http://[::1]:8080/test/post/%v"}, "1"},
+		{Hook{URL: "http://169.254.169.254/latest/meta-data/%v"}, "iam"},
+		{Hook{URL: "http://10.0.0.1/%v"}, "1"},
+
+		// hosts must be in the allowlist
+		{Hook{URL: "http://127.0.0.1:8080/test/post/%v", Outbound: &OutboundPolicy{Hosts: []string{"*.example.com"}}}, "1"},
+
+		// ids can't change the host
+		{Hook{URL: "http://127.0.0.1:8080%v", Outbound: &OutboundPolicy{Hosts: []string{"127.0.0.1"}}}, "@localhost"},
+	}
+
+	for _, test := range tests {
+		err := test.hook.Prepare()
+		if err != nil {
+			t.Errorf("ERROR: error preparing hook\n\t%v\n", err)
+			continue
+		}
+
+		req, err := test.hook.NewRequest(TaskJSON{ID: test.id})
+		if err != nil {
+			t.Errorf("ERROR: error building request\n\t%v\n", err)
+			continue
+		}
+
+		_, err = test.hook.Do("outbound", req)
+		hookErr, ok := err.(*HookError)
+		if !ok || !hookErr.Blocked || hookErr.Status != http.StatusForbidden {
+			t.Errorf("ERROR: request to %v should be blocked\n\t%v\n", req.URL, err)
+		}
+	}
+}
+
+func TestOutboundShouldFail2(t *testing.T) {
+	hook := Hook{URL: "http://127.0.0.1:8080/test/post/%v"}
+	err := hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: error preparing hook\n\t%v\n", err)
+	}
+
+	req, err := hook.NewRequest(TaskJSON{ID: "1"})
+	if err != nil {
+		t.Fatalf("ERROR: error building request\n\t%v\n", err)
+	}
+
+	_, err = hook.Do("outbound", req)
+	if err != nil {
+		t.Errorf("ERROR: the unchanged request should be allowed\n\t%v\n", err)
+	}
+
+	// proxies would hide the upstream's
+	// IP from the policy
+	if transport, ok := hook.client.Transport.(*http.Transport); !ok || transport.Proxy != nil {
+		t.Errorf("ERROR: hook clients should not use a proxy\n")
+	}
+
+	// anything that changes the host or scheme
+	// after formatting should be refused
+	for _, origin := range []string{"http://localhost:8080", "https://127.0.0.1:8080", "http://127.0.0.1:9090"} {
+		changed := *req.URL
+		u, _ := url.Parse(origin)
+		changed.Scheme, changed.Host = u.Scheme, u.Host
+
+		changedReq := req.Clone(req.Context())
+		changedReq.URL = &changed
+		_, err = hook.Do("outbound", changedReq)
+		if hookErr, ok := err.(*HookError); !ok || !hookErr.Blocked {
+			t.Errorf("ERROR: request to %v should be blocked\n\t%v\n", origin, err)
+		}
+	}
+
+	// redirects can't leave the origin
+	hook = Hook{URL: "http://127.0.0.1:8080/test/redirect/%v"}
+	err = hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: error preparing hook\n\t%v\n", err)
+	}
+	for host, blocked := range map[string]bool{"127.0.0.1:8080": false, "localhost:8080": true} {
+		req, err = hook.NewRequest(TaskJSON{ID: host})
+		if err != nil {
+			t.Fatalf("ERROR: error building request\n\t%v\n", err)
+		}
+
+		_, err = hook.Do("outbound", req)
+		if hookErr, ok := err.(*HookError); blocked && (!ok || !hookErr.Blocked) {
+			t.Errorf("ERROR: redirect to %v should be blocked\n\t%v\n", host, err)
+		} else if !blocked && err != nil {
+			t.Errorf("ERROR: redirect to %v should be followed\n\t%v\n", host, err)
+		}
+	}
+
+	// hooks with params in their host
+	// need allowed hosts
+	hook = Hook{URL: "http://{tenant}.example.com/%v"}
+	err = hook.Prepare()
+	if err == nil {
+		t.Errorf("ERROR: hooks with params in their host should need allowed hosts\n")
+	}
+}
+
+// * Hook management * //
+
+// useTemporaryHooksFile saves hooks changed through
+// the API to a temporary file rather than rewriting
+// the test config, returning a func to clean it up
+func useTemporaryHooksFile(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "sentiment-hooks")
+	if err != nil {
+		t.Fatalf("ERROR: error making temporary directory\n\t%v\n", err)
+	}
+
+	Config.Admin.HooksFile = path.Join(dir, "hooks.json")
+	return func() {
+		Config.Admin.HooksFile = ""
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHookAPIShouldPass1(t *testing.T) {
+	defer useTemporaryHooksFile(t)()
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "hooks/managed", `{"url": "http://127.0.0.1:8080/test/post/%v"}`, http.StatusCreated},
+		{"POST", "hooks/managed", `{"url": "http://127.0.0.1:8080/test/post/%v"}`, http.StatusConflict},
+		{"GET", "hooks/managed", ``, http.StatusOK},
+		{"PUT", "hooks/managed", `{"url": "http://127.0.0.1:8080/test/comment/%v", "key": "text", "headers": {"Auth": ["${env:COMMENT_TOKEN}"]}}`, http.StatusOK},
+		{"PUT", "hooks/missing", `{"url": "http://127.0.0.1:8080/test/post/%v"}`, http.StatusNotFound},
+		{"PUT", "hooks/managed", `{"url": "http://127.0.0.1:8080/test/post/%v", "key": "$.["}`, http.StatusBadRequest},
+		{"PUT", "hooks/managed", `{"url": "http://127.0.0.1:8080/test/post/%v", "headers": {"Auth": ["${env:ADMIN_TOKEN}"]}}`, http.StatusBadRequest},
+		{"PUT", "hooks/managed", `{"url": "http://127.0.0.1:8080/test/post/%v?s=${file:/etc/shadow}"}`, http.StatusBadRequest},
+		{"PUT", "hooks/managed", `{"url": "http://127.0.0.1:8080/test/post/%v", "signing": {"secret": "\u0024{env:ADMIN_TOKEN}"}}`, http.StatusBadRequest},
+		{"PUT", "hooks/managed", `["not", "a", "hook"]`, http.StatusBadRequest},
+		{"DELETE", "hooks/post", ``, http.StatusConflict},
+		{"DELETE", "hooks/mood", ``, http.StatusConflict},
+	}
+
+	for _, step := range steps {
+		status, body, err := admin(step.method, step.path, "ADMIN_SECRET", step.body)
+		if err != nil {
+			t.Errorf("ERROR: error making request\n\t%v\n", err)
+		}
+		if status != step.status {
+			t.Errorf("ERROR: %v /%v should respond with %v\n\t%v %v\n", step.method, step.path, step.status, status, string(body))
+		}
+	}
+
+	// the updated hook should be used by tasks
+	status, body, err := post("task", `{
+		"recordingId": "1",
+		"hookId": "managed"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("ERROR: the managed hook should be usable\n\t%v\n", string(body))
+	}
+
+	saved := map[string]json.RawMessage{}
+	data, err := ioutil.ReadFile(Config.Admin.HooksFile)
+	if err != nil {
+		t.Fatalf("ERROR: error reading saved hooks\n\t%v\n", err)
+	}
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling saved hooks\n\t%v\n", err)
+	}
+	if !strings.Contains(string(saved["managed"]), "${env:COMMENT_TOKEN}") || saved["post"] == nil {
+		t.Errorf("ERROR: every hook should be saved as it was given\n\t%v\n", string(data))
+	}
+
+	status, body, err = admin("DELETE", "hooks/managed", "ADMIN_SECRET", "")
+	if err != nil || status != http.StatusOK {
+		t.Errorf("ERROR: the managed hook should be deleted\n\t%v\n", string(body))
+	}
+
+	_, _, _, err = GetHookResponse(TaskJSON{ID: "1", HookID: "managed"})
+	if err == nil {
+		t.Errorf("ERROR: deleted hooks should no longer be found\n")
+	}
+}
+
+func TestHookAPIShouldPass2(t *testing.T) {
+	defer useTemporaryHooksFile(t)()
+
+	previous := CurrentHooks()["post"]
+
+	status, body, err := admin("PUT", "hooks/post", "ADMIN_SECRET", `{"url": "http://127.0.0.1:8080/test/comment/%v", "key": "text"}`)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("ERROR: the hook should be replaced\n\t%v\n", string(body))
+	}
+	defer admin("PUT", "hooks/post", "ADMIN_SECRET", `{"url": "http://127.0.0.1:8080/test/post/%v"}`)
+
+	// a task which already looked up the hook
+	// keeps using the definition it started with
+	if previous.URL != "http://127.0.0.1:8080/test/post/%v" {
+		t.Errorf("ERROR: earlier hook definitions shouldn't change\n\t%v\n", previous.URL)
+	}
+	if CurrentHooks()["post"].URL != "http://127.0.0.1:8080/test/comment/%v" {
+		t.Errorf("ERROR: the new hook definition should be current\n\t%v\n", CurrentHooks()["post"].URL)
+	}
+}
+
+func TestHookAPIShouldPass3(t *testing.T) {
+	// without a hooks file changes are kept
+	// in memory and the config isn't rewritten
+	before, err := ioutil.ReadFile(config)
+	if err != nil {
+		t.Fatalf("ERROR: error reading the config\n\t%v\n", err)
+	}
+
+	status, body, err := admin("POST", "hooks/inMemory", "ADMIN_SECRET", `{"url": "http://127.0.0.1:8080/test/post/%v"}`)
+	if err != nil || status != http.StatusCreated {
+		t.Fatalf("ERROR: the hook should be created\n\t%v\n", string(body))
+	}
+	defer admin("DELETE", "hooks/inMemory", "ADMIN_SECRET", "")
+
+	if _, ok := CurrentHooks()["inMemory"]; !ok {
+		t.Errorf("ERROR: the hook should be kept in memory\n")
+	}
+
+	after, err := ioutil.ReadFile(config)
+	if err != nil || !bytes.Equal(before, after) {
+		t.Errorf("ERROR: the config file shouldn't be rewritten\n\t%v\n", err)
+	}
+}
+
+func TestHookAPIShouldFail1(t *testing.T) {
+	for _, token := range []string{"", "WRONG_SECRET"} {
+		status, _, err := admin("GET", "hooks", token, "")
+		if err != nil {
+			t.Errorf("ERROR: error making request\n\t%v\n", err)
+		}
+		if status != http.StatusUnauthorized {
+			t.Errorf("ERROR: requests without the admin token should be refused\n\t%v\n", status)
+		}
+	}
+
+	status, _, _ := admin("DELETE", "hooks/comment", "", "")
+	if status != http.StatusUnauthorized {
+		t.Errorf("ERROR: hooks shouldn't be deleted without the admin token\n\t%v\n", status)
+	}
+	if _, ok := CurrentHooks()["comment"]; !ok {
+		t.Errorf("ERROR: the hook should still exist\n")
+	}
+}
+
+func TestHookAPIShouldFail2(t *testing.T) {
+	defer useTemporaryHooksFile(t)()
+
+	// a hooks file without the default
+	// hook is refused
+	err := ioutil.WriteFile(Config.Admin.HooksFile, []byte(`{"other": {"url": "http://127.0.0.1:8080/test/post/%v"}}`), 0600)
+	if err != nil {
+		t.Fatalf("ERROR: error writing hooks file\n\t%v\n", err)
+	}
+	if err = LoadHooks(); err == nil {
+		t.Errorf("ERROR: hooks without the default hook '%v' should be refused\n", Config.DefaultHook)
+	}
+	if _, ok := CurrentHooks()[Config.DefaultHook]; !ok {
+		t.Errorf("ERROR: the current hooks shouldn't change\n")
+	}
+
+	// tasks in a batch run against the hook
+	// they were given, not whatever the
+	// hook id currently points at
+	hook := Hook{URL: "http://127.0.0.1:8080/test/post/%v"}
+	err = hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+	}
+	r, err := runHookTask("unlisted", &hook, TaskJSON{ID: "1", HookID: "unlisted"})
+	if err != nil || r.Analysis == nil {
+		t.Errorf("ERROR: the task should run with the given hook\n\t%v\n", err)
+	}
+}
+
+// * Dry runs * //
+
+func TestDryRunShouldPass1(t *testing.T) {
+	successful, hooked := atomic.LoadInt64(&count), atomic.LoadInt64(&hookCount)
+
+	status, body, err := admin("POST", "dryrun", "ADMIN_SECRET", `{
+		"recordingId": "1",
+		"hookId": "comment"
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	d := DryRunResponse{}
+	err = json.Unmarshal(body, &d)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	if d.Request == nil || d.Request.URL != "http://127.0.0.1:8080/test/comment/1" {
+		t.Errorf("ERROR: the upstream request should be given\n\t%v\n", string(body))
+	}
+	if d.Request != nil && d.Request.Headers["Auth"][0] != "${env:COMMENT_TOKEN}" {
+		t.Errorf("ERROR: secrets should be redacted from the request\n\t%v\n", d.Request.Headers)
+	}
+	if d.Response == nil || d.Response.Status != http.StatusOK || d.Response.Size == 0 {
+		t.Errorf("ERROR: the upstream response should be given\n\t%v\n", string(body))
+	}
+	if len(d.Steps) != 2 || d.Steps[0].Step != "parse" || d.Steps[1].Step != "key" {
+		t.Errorf("ERROR: each extraction step should be given\n\t%+v\n", d.Steps)
+	}
+	if d.Text == nil || *d.Text == "" || d.Error != nil {
+		t.Errorf("ERROR: the text should be given without an error\n\t%v\n", string(body))
+	}
+
+	if atomic.LoadInt64(&count) != successful || atomic.LoadInt64(&hookCount) != hooked {
+		t.Errorf("ERROR: dry runs shouldn't count toward the status metrics\n")
+	}
+}
+
+func TestDryRunShouldPass2(t *testing.T) {
+	status, body, err := admin("POST", "dryrun", "ADMIN_SECRET", `{
+		"recordingId": "1",
+		"hook": {
+			"url": "http://127.0.0.1:8080/test/thread/%v",
+			"key": "$.data.missing"
+		}
+	}`)
+	if err != nil {
+		t.Errorf("ERROR: error trying to post\n\t%v\n", err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	d := DryRunResponse{}
+	err = json.Unmarshal(body, &d)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	if d.Error == nil || d.Text != nil {
+		t.Errorf("ERROR: the failed extraction should be given\n\t%v\n", string(body))
+	}
+	if len(d.Steps) != 2 || d.Steps[1].Detail != "the key $.data.missing matched 0 values" {
+		t.Errorf("ERROR: the steps up to the failure should be given\n\t%+v\n", d.Steps)
+	}
+
+	// failed upstream responses
+	// should still be shown
+	for hook, header := range map[string]string{"unauthorized": "", "oauth": "Authorization"} {
+		status, body, err = admin("POST", "dryrun", "ADMIN_SECRET", fmt.Sprintf(`{
+			"recordingId": "dry",
+			"hookId": "%v"
+		}`, hook))
+		if err != nil || status != http.StatusOK {
+			t.Errorf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+		}
+
+		d = DryRunResponse{}
+		json.Unmarshal(body, &d)
+		if hook == "unauthorized" && (d.Response == nil || d.Response.Status != http.StatusUnauthorized || d.Error == nil) {
+			t.Errorf("ERROR: the unaccepted response should be given\n\t%v\n", string(body))
+		}
+		if header != "" && (d.Request == nil || d.Request.Headers[header][0] != "[REDACTED]") {
+			t.Errorf("ERROR: the %v header should be redacted\n\t%v\n", header, string(body))
+		}
+	}
+}
+
+func TestDryRunShouldFail1(t *testing.T) {
+	status, _, _ := admin("POST", "dryrun", "", `{"recordingId": "1"}`)
+	if status != http.StatusUnauthorized {
+		t.Errorf("ERROR: dry runs should need the admin token\n\t%v\n", status)
+	}
+
+	status, body, _ := admin("POST", "dryrun", "ADMIN_SECRET", `{"recordingId": "1", "hook": {"url": "http://127.0.0.1:8080/%v", "key": "$.["}}`)
+	if status != http.StatusBadRequest {
+		t.Errorf("ERROR: invalid hooks should be refused\n\t%v\n", string(body))
+	}
+
+	// inline hooks can't send secrets
+	// which aren't allowed anywhere
+	for _, hook := range []string{
+		`{"url": "http://127.0.0.1:8080/test/post/%v?token=${env:ADMIN_TOKEN}"}`,
+		`{"url": "http://127.0.0.1:8080/test/post/%v", "headers": {"Leak": ["${file:/etc/passwd}"]}}`,
+	} {
+		status, body, _ = admin("POST", "dryrun", "ADMIN_SECRET", `{"recordingId": "1", "hook": `+hook+`}`)
+		if status != http.StatusBadRequest || !strings.Contains(string(body), "isn't allowed") {
+			t.Errorf("ERROR: hooks with secrets should be refused\n\t%v\n", string(body))
+		}
+	}
+}
+
+func TestCronShouldPass1(t *testing.T) {
+	// a Sunday
+	now := time.Date(2026, time.October, 18, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, time.October, 18, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 8 1 * 0", time.Date(2026, time.October, 25, 8, 30, 0, 0, time.UTC)},
+		{"5 10 * * 7", time.Date(2026, time.October, 25, 10, 5, 0, 0, time.UTC)},
+		{"0,45 10 18 10 *", time.Date(2026, time.October, 18, 10, 45, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		c, err := ParseCron(test.expr)
+		if err != nil {
+			t.Errorf("ERROR: '%v' should parse\n\t%v\n", test.expr, err)
+			continue
+		}
+
+		if next := c.Next(now); !next.Equal(test.next) {
+			t.Errorf("ERROR: '%v' should next run at %v, not %v\n", test.expr, test.next, next)
+		}
+	}
+}
+
+func TestCronShouldFail1(t *testing.T) {
+	for _, expr := range []string{"* * *", "60 * * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *", "0 0 30 2 *", "@often"} {
+		_, err := ParseCron(expr)
+		if err == nil {
+			t.Errorf("ERROR: '%v' should not parse\n", expr)
+		}
+	}
+}
+
+func TestScheduleShouldPass1(t *testing.T) {
+	// the mood hook is polled every 200ms and
+	// turns sour after 3 polls, so the rolling
+	// sentiment drops below the threshold
+	var alerts []Alert
+	timeout := time.After(10 * time.Second)
+	for len(alerts) == 0 || alerts[len(alerts)-1].Reason != AlertBelow {
+		select {
+		case a := <-TestAlerts:
+			if a.Header.Get("Alert-Token") != "ALERT_TOKEN" || a.Header.Get("X-Sentiment-Schedule") != "mood" {
+				t.Errorf("ERROR: alerts should be sent with their headers\n\t%v\n", a.Header)
+			}
+			if a.Header.Get(SignatureHeader) != Sign("CALLBACK_SECRET", a.Body) {
+				t.Errorf("ERROR: alerts should be signed\n\t%v\n", a.Header)
+			}
+
+			alert := Alert{}
+			err := json.Unmarshal(a.Body, &alert)
+			if err != nil {
+				t.Fatalf("ERROR: error unmarshalling alert\n\t%v\n\t%v\n", err, string(a.Body))
+			}
+			alerts = append(alerts, alert)
+
+		case <-timeout:
+			t.Fatalf("ERROR: a below alert should have been sent\n\t%+v\n", alerts)
+		}
+	}
+
+	below := alerts[len(alerts)-1]
+	if below.Rolling >= 0.4 || below.Threshold != 0.4 || below.HookID != "mood" || below.Previous == nil {
+		t.Errorf("ERROR: the alert should give the rolling sentiment\n\t%+v\n", below)
+	}
+	if alerts[0].Reason != AlertChange || *alerts[0].Previous-alerts[0].Rolling < 0.25 {
+		t.Errorf("ERROR: the sharp change should be alerted first\n\t%+v\n", alerts)
+	}
+
+	status, body, err := admin("GET", "schedules/mood", "ADMIN_SECRET", "")
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	state := ScheduleState{}
+	err = json.Unmarshal(body, &state)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	if state.Runs < 4 || len(state.History) < 4 || state.NextRun == nil || state.Interval == nil {
+		t.Errorf("ERROR: the schedule's runs should be recorded\n\t%v\n", string(body))
+	}
+	if state.History[0].Score != 1 || state.History[len(state.History)-1].Score != 0 {
+		t.Errorf("ERROR: the score history should be kept in order\n\t%+v\n", state.History)
+	}
+	if state.Rolling == nil || *state.Rolling >= 0.4 {
+		t.Errorf("ERROR: the rolling sentiment should be given\n\t%v\n", string(body))
+	}
+	if len(state.Alerts) < 2 || state.Alerts[0].Delivery == nil {
+		t.Errorf("ERROR: the alerts should be given with their deliveries\n\t%v\n", string(body))
+	}
+
+	status, body, _ = admin("GET", "schedules", "ADMIN_SECRET", "")
+	if status != http.StatusOK || !strings.Contains(string(body), `"mood":{`) {
+		t.Errorf("ERROR: every schedule should be listed\n\t%v\n", string(body))
+	}
+}
+
+func TestScheduleShouldFail1(t *testing.T) {
+	status, _, _ := admin("GET", "schedules", "", "")
+	if status != http.StatusUnauthorized {
+		t.Errorf("ERROR: schedules should need the admin token\n\t%v\n", status)
+	}
+
+	status, _, _ = admin("GET", "schedules/missing", "ADMIN_SECRET", "")
+	if status != http.StatusNotFound {
+		t.Errorf("ERROR: missing schedules should give 404\n\t%v\n", status)
+	}
+
+	low, high := -0.5, 0.5
+	for _, c := range []ScheduleConfig{
+		{Interval: Duration(time.Minute)},
+		{ID: "1"},
+		{ID: "1", Interval: Duration(time.Minute), Cron: "@hourly"},
+		{ID: "1", Cron: "61 * * * *"},
+		{ID: "1", Interval: Duration(time.Minute), Window: 10, History: 5},
+		{ID: "1", Interval: Duration(time.Minute), Alert: &AlertConfig{Callback: Callback{URL: "/relative"}, Above: &high}},
+		{ID: "1", Interval: Duration(time.Minute), Alert: &AlertConfig{Callback: Callback{URL: "http://localhost/"}}},
+		{ID: "1", Interval: Duration(time.Minute), Alert: &AlertConfig{Callback: Callback{URL: "http://localhost/"}, Below: &low}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("ERROR: the schedule should be invalid\n\t%+v\n", c)
+		}
+	}
+}
+
+func TestPaginationShouldPass1(t *testing.T) {
+	tests := map[string]*PaginationConfig{
+		"link":   {Type: PageLink, Next: "$.next"},
+		"header": {Type: PageHeader},
+		"page":   {Type: PageNumber},
+		"offset": {Type: PageOffset},
+		"cursor": {Type: PageCursor, Next: "$.meta.cursor", Param: "after"},
+	}
+
+	for strategy, pagination := range tests {
+		hook := Hook{
+			URL:        "http://127.0.0.1:8080/test/pages/" + strategy + "?thread=%v",
+			Key:        "$.comments[*].text",
+			Separate:   true,
+			Pagination: pagination,
+		}
+		err := hook.Prepare()
+		if err != nil {
+			t.Fatalf("ERROR: the %v hook should be valid\n\t%v\n", strategy, err)
+		}
+
+		r, err := hook.Fetch("pages-"+strategy, TaskJSON{ID: "1"})
+		if err != nil {
+			t.Errorf("ERROR: the %v pages should be fetched\n\t%v\n", strategy, err)
+			continue
+		}
+
+		if r.Text != "comment 1 comment 2 comment 3 comment 4 comment 5" || len(r.Matches) != 5 {
+			t.Errorf("ERROR: every %v page should be combined in order\n\t%q\n", strategy, r.Text)
+		}
+	}
+}
+
+func TestPaginationShouldPass2(t *testing.T) {
+	status, body, err := post("task", `{"hookId": "pagedThread", "recordingId": "1"}`)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	resp := CollectionResponse{}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	if resp.Aggregate.Count != 5 || len(resp.Items) != 5 || resp.Items[4].ID != 5.0 {
+		t.Errorf("ERROR: every page's items should be scored\n\t%v\n", string(body))
+	}
+
+	// pages stop at the cap
+	hook := Hook{
+		URL:        "http://127.0.0.1:8080/test/pages/link?thread=%v",
+		Key:        "comments",
+		Collection: &CollectionFields{},
+		Pagination: &PaginationConfig{Type: PageLink, Next: "next", MaxPages: 2},
+	}
+	err = hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+	}
+
+	r, err := hook.Fetch("pages-capped", TaskJSON{ID: "1"})
+	if err != nil || len(r.Items) != 4 {
+		t.Errorf("ERROR: only the first 2 pages should be fetched\n\t%v\n\t%+v\n", err, r)
+	}
+
+	if link := nextLink(`<https://a.example.com/?page=1>; rel="prev first", <https://a.example.com/?page=3>; REL=next`); link != "https://a.example.com/?page=3" {
+		t.Errorf("ERROR: the next link should be found\n\t%v\n", link)
+	}
+}
+
+func TestPaginationShouldPass3(t *testing.T) {
+	// every strategy should stop at an empty
+	// last page instead of failing the fetch
+	tests := map[string]*PaginationConfig{
+		"link":   {Type: PageLink, Next: "$.next"},
+		"header": {Type: PageHeader},
+		"cursor": {Type: PageCursor, Next: "$.meta.cursor", Param: "after"},
+	}
+
+	for strategy, pagination := range tests {
+		hook := Hook{
+			URL:        "http://127.0.0.1:8080/test/pages/" + strategy + "?thread=%v&trailing=1",
+			Key:        "$.comments[*].text",
+			Separate:   true,
+			Pagination: pagination,
+		}
+		err := hook.Prepare()
+		if err != nil {
+			t.Fatalf("ERROR: the %v hook should be valid\n\t%v\n", strategy, err)
+		}
+
+		r, err := hook.Fetch("pages-trailing-"+strategy, TaskJSON{ID: "1"})
+		if err != nil {
+			t.Errorf("ERROR: the %v pages should be fetched despite the empty last page\n\t%v\n", strategy, err)
+			continue
+		}
+
+		if r.Text != "comment 1 comment 2 comment 3 comment 4 comment 5" || len(r.Matches) != 5 {
+			t.Errorf("ERROR: the empty %v page should be skipped\n\t%q\n", strategy, r.Text)
+		}
+	}
+}
+
+func TestPaginationShouldFail1(t *testing.T) {
+	for _, pagination := range []PaginationConfig{
+		{Type: "scroll"},
+		{Type: PageLink},
+		{Type: PageCursor, Next: "$.["},
+		{Type: PageNumber, MaxPages: -1},
+	} {
+		hook := Hook{URL: "http://127.0.0.1:8080/test/pages/page?thread=%v", Key: "comments", Pagination: &pagination}
+		if err := hook.Prepare(); err == nil {
+			t.Errorf("ERROR: the pagination should be invalid\n\t%+v\n", pagination)
+		}
+	}
+
+	// plain text pages have no items
+	// for the offset to count
+	plain := Hook{URL: "http://127.0.0.1:8080/test/post/%v", Pagination: &PaginationConfig{Type: PageOffset}}
+	if err := plain.Prepare(); err == nil {
+		t.Errorf("ERROR: offset pagination should need a key\n")
+	}
+
+	// next links can't leave the
+	// hook's origin
+	hook := Hook{
+		URL:        "http://127.0.0.1:8080/test/pages-elsewhere/%v",
+		Key:        "text",
+		Pagination: &PaginationConfig{Type: PageHeader},
+	}
+	err := hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+	}
+
+	_, err = hook.Fetch("pages-elsewhere", TaskJSON{ID: "1"})
+	if err == nil || !strings.Contains(err.Error(), "blocked by outbound policy") {
+		t.Errorf("ERROR: the next page should be blocked\n\t%v\n", err)
+	}
+}
+
+func TestXPathShouldPass1(t *testing.T) {
+	doc, err := parseXML(TestXML)
+	if err != nil {
+		t.Fatalf("ERROR: the document should parse\n\t%v\n", err)
+	}
+
+	tests := []struct {
+		xpath string
+		found []interface{}
+	}{
+		{"/thread/title", []interface{}{"Opening night"}},
+		{"/thread/@id", []interface{}{"42"}},
+		{"//comment/@author", []interface{}{"ann", "bob"}},
+		{"/thread/comments/comment[2]/body/text()", []interface{}{"The seats were terrible"}},
+		{"//caption/text()", []interface{}{"What a great show", "Sad ending though"}},
+		{"/thread/comments/comment/body/@format", []interface{}{"plain"}},
+		{"/thread/./title", []interface{}{"Opening night"}},
+		{"/thread/*/comment[1]/@id", []interface{}{"1"}},
+		{"/thread/missing", []interface{}{}},
+	}
+
+	for _, test := range tests {
+		path, err := CompileXPath(test.xpath)
+		if err != nil {
+			t.Errorf("ERROR: '%v' should compile\n\t%v\n", test.xpath, err)
+			continue
+		}
+
+		found := path.Find(doc)
+		for i := range found {
+			if text, ok := textValue(found[i]); ok {
+				found[i] = text
+			}
+		}
+		if fmt.Sprint(found) != fmt.Sprint(test.found) {
+			t.Errorf("ERROR: '%v' should find %v, not %v\n", test.xpath, test.found, found)
+		}
+	}
+
+	for _, xpath := range []string{"", "/a//", "a[0]", "/a/@b/c", "//*", "/a[x]", "a[1"} {
+		if _, err := CompileXPath(xpath); err == nil {
+			t.Errorf("ERROR: '%v' should not compile\n", xpath)
+		}
+	}
+}
+
+func TestFormatShouldPass1(t *testing.T) {
+	tests := []struct {
+		hook Hook
+		text string
+	}{
+		{Hook{Format: FormatXML, Key: "//comment/body"}, "I love this theatre The seats were terrible"},
+		{Hook{Format: FormatCSV, Key: "answer", CSV: &CSVConfig{Delimiter: ";"}}, "Great service; happy Bad wait times"},
+		{Hook{Format: FormatCSV, Key: "4", CSV: &CSVConfig{Delimiter: ";", NoHeader: true}}, "answer Great service; happy Bad wait times"},
+	}
+
+	for _, test := range tests {
+		test.hook.URL = "http://127.0.0.1:8080/test/formats/" + test.hook.Format
+		err := test.hook.Prepare()
+		if err != nil {
+			t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+		}
+
+		r, err := test.hook.Fetch("format-"+test.hook.Key, TaskJSON{ID: "1"})
+		if err != nil || r.Text != test.text {
+			t.Errorf("ERROR: the %v text should be found\n\t%v\n\t%+v\n", test.hook.Format, err, r)
+		}
+	}
+
+	// collections and time series
+	// work the same
+	hook := Hook{
+		URL:        "http://127.0.0.1:8080/test/formats/xml",
+		Format:     FormatXML,
+		Key:        "/thread/comments/comment",
+		Collection: &CollectionFields{ID: "@id", Text: "body"},
+	}
+	err := hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+	}
+	r, err := hook.Fetch("format-collection", TaskJSON{ID: "1"})
+	if err != nil || len(r.Items) != 2 || r.Items[1].ID != "2" || r.Items[1].Text != "The seats were terrible" {
+		t.Errorf("ERROR: the XML collection should be read\n\t%v\n\t%+v\n", err, r)
+	}
+
+	for start, hook := range map[float64]Hook{
+		2500: {Format: FormatXML, Key: "//caption", Time: true, TimeFields: &TimeFields{Start: "@start", End: "@end", Text: "text()"}},
+		1500: {Format: FormatCSV, Time: true, CSV: &CSVConfig{Delimiter: ";"}, TimeFields: &TimeFields{Text: "answer"}},
+	} {
+		hook.URL = "http://127.0.0.1:8080/test/formats/" + hook.Format
+		err := hook.Prepare()
+		if err != nil {
+			t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+		}
+
+		r, err := hook.Fetch("format-time-"+hook.Format, TaskJSON{ID: "1"})
+		if err != nil || len(r.Series) != 2 || r.Series[1].Start != start || r.Series[0].Text == "" {
+			t.Errorf("ERROR: the %v time series should be read in seconds\n\t%v\n\t%+v\n", hook.Format, err, r)
+		}
+	}
+}
+
+func TestFormatShouldPass2(t *testing.T) {
+	status, body, err := post("task", `{"hookId": "feed", "recordingId": "reviews"}`)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("ERROR: status returned should be 200 OK\n\t%v\n", string(body))
+	}
+
+	resp := CollectionResponse{}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	if len(resp.Items) != 2 || resp.Aggregate.Count != 2 {
+		t.Fatalf("ERROR: every feed entry should be analyzed\n\t%v\n", string(body))
+	}
+
+	first, second := resp.Items[0], resp.Items[1]
+	if first.ID != "review-1" || first.Title != "A great launch" || first.Text != "A great launch\nEveryone was happy & excited" {
+		t.Errorf("ERROR: the entry should be read without its HTML\n\t%+v\n", first)
+	}
+	if first.Time == nil || !first.Time.Equal(time.Date(2026, time.March, 3, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("ERROR: the entry should be given its time\n\t%+v\n", first)
+	}
+	if second.ID != "https://example.com/reviews/2" || second.Text != "Support is slow\nA bad, sad experience" || second.Time == nil {
+		t.Errorf("ERROR: the entry should fall back to its link and content\n\t%+v\n", second)
+	}
+	if second.Analysis == nil || second.Analysis.Score != 0 || first.Analysis.Score != 1 {
+		t.Errorf("ERROR: each entry should be scored\n\t%v\n", string(body))
+	}
+
+	hook := Hook{URL: "http://127.0.0.1:8080/test/formats/atom", Format: FormatFeed}
+	err = hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+	}
+
+	r, err := hook.Fetch("format-atom", TaskJSON{ID: "1"})
+	if err != nil || len(r.Items) != 1 {
+		t.Fatalf("ERROR: the Atom entry should be read\n\t%v\n\t%+v\n", err, r)
+	}
+	if entry := r.Items[0]; entry.ID != "https://example.com/entries/1" || entry.Title != "Everything is great" || entry.Time == nil {
+		t.Errorf("ERROR: the Atom entry should give its link, title and time\n\t%+v\n", entry)
+	}
+}
+
+func TestFormatShouldFail1(t *testing.T) {
+	for _, hook := range []Hook{
+		{Format: "yaml"},
+		{Format: FormatXML},
+		{Format: FormatCSV, Key: "a", CSV: &CSVConfig{Delimiter: ";;"}},
+		{Format: FormatFeed, Key: "title"},
+		{Format: FormatXML, Key: "/a/@b/c"},
+	} {
+		hook.URL = "http://127.0.0.1:8080/test/formats/xml"
+		if err := hook.Prepare(); err == nil {
+			t.Errorf("ERROR: the hook should be invalid\n\t%+v\n", hook)
+		}
+	}
+
+	// responses in other formats
+	// can't be read
+	for format, key := range map[string]string{FormatXML: "/a", FormatFeed: ""} {
+		hook := Hook{URL: "http://127.0.0.1:8080/test/formats/csv", Format: format, Key: key}
+		err := hook.Prepare()
+		if err != nil {
+			t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+		}
+
+		_, err = hook.Fetch("format-wrong-"+format, TaskJSON{ID: "1"})
+		if err == nil {
+			t.Errorf("ERROR: the CSV response should not be read as %v\n", format)
+		}
+	}
+}
+
+func TestTranscriptShouldPass1(t *testing.T) {
+	expected := []TimeSeries{
+		{Start: 0, End: 2500, Speaker: "ANN", Text: "What a great show"},
+		{Start: 2500, End: 4000, Speaker: "BOB", Text: "Sad ending though"},
+		{Start: 4000, End: 65250, Text: "I love it"},
+	}
+	series, err := ParseTranscript(FormatSRT, TestSRT)
+	if err != nil || !reflect.DeepEqual(series, expected) {
+		t.Errorf("ERROR: the SRT cues should be read with their speakers\n\t%v\n\t%+v\n", err, series)
+	}
+
+	expected = []TimeSeries{
+		{Start: 0, End: 2500, Speaker: "Ann", Text: "What a great show"},
+		{Start: 2500, End: 4000, Speaker: "Bob", Text: "Sad ending though"},
+		{Start: 2500, End: 4000, Speaker: "Ann", Text: "I love it & you"},
+	}
+	series, err = ParseTranscript(FormatVTT, TestVTT)
+	if err != nil || !reflect.DeepEqual(series, expected) {
+		t.Errorf("ERROR: the WebVTT cues should be read with their voices\n\t%v\n\t%+v\n", err, series)
+	}
+
+	expected = []TimeSeries{
+		{Start: 0, End: 2500, Speaker: "SPEAKER_00", Text: "What a great show."},
+		{Start: 2500, End: 3400, Speaker: "SPEAKER_01", Text: "Sad ending though."},
+		{Start: 3500, End: 4250, Speaker: "SPEAKER_00", Text: "I love it"},
+	}
+	series, err = ParseTranscript(FormatWhisper, TestWhisper)
+	if err != nil || !reflect.DeepEqual(series, expected) {
+		t.Errorf("ERROR: the Whisper segments should be split by speaker\n\t%v\n\t%+v\n", err, series)
+	}
+
+	series, err = ParseTranscript(FormatWhisper, []byte(`[{"start": 1.5, "end": 2, "text": " hi "}]`))
+	if err != nil || len(series) != 1 || series[0].Start != 1500 || series[0].Text != "hi" {
+		t.Errorf("ERROR: a bare array of segments should be read\n\t%v\n\t%+v\n", err, series)
+	}
+
+	for contentType, format := range map[string]string{
+		"text/vtt; charset=utf-8": FormatVTT,
+		"application/x-subrip":    FormatSRT,
+		"application/json":        FormatWhisper,
+	} {
+		if detected := DetectTranscriptFormat(nil, contentType); detected != format {
+			t.Errorf("ERROR: %v should be detected as %v, not %v\n", contentType, format, detected)
+		}
+	}
+	for format, data := range map[string][]byte{FormatSRT: TestSRT, FormatVTT: TestVTT, FormatWhisper: TestWhisper} {
+		if detected := DetectTranscriptFormat(data, "text/plain"); detected != format {
+			t.Errorf("ERROR: the transcript should be detected as %v, not %v\n", format, detected)
+		}
+	}
+}
+
+func TestTranscriptShouldPass2(t *testing.T) {
+	for _, format := range []string{FormatSRT, FormatVTT, FormatWhisper} {
+		hook := Hook{URL: "http://127.0.0.1:8080/test/formats/" + format, Format: format}
+		err := hook.Prepare()
+		if err != nil {
+			t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+		}
+
+		r, err := hook.Fetch("transcript-"+format, TaskJSON{ID: "1"})
+		if err != nil || len(r.Series) != 3 || r.Series[1].Speaker == "" || r.Text != TurnTimeSeriesIntoText(r.Series) {
+			t.Errorf("ERROR: the %v transcript should be read as a time series\n\t%v\n\t%+v\n", format, err, r)
+		}
+	}
+
+	status, body, err := upload("transcript?highlights=1", "application/x-subrip", string(TestSRT))
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("ERROR: status returned should be 200 OK\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	resp := TimeSeriesResponse{}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		t.Fatalf("ERROR: error unmarshalling JSON response\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	if resp.Metadata == nil || len(resp.Series) != 3 {
+		t.Fatalf("ERROR: the transcript and each cue should be analyzed\n\t%v\n", string(body))
+	}
+	if resp.Series[0].Score != 1 || resp.Series[1].Score != 0 || resp.Series[1].Speaker != "BOB" {
+		t.Errorf("ERROR: each cue should be scored with its speaker\n\t%v\n", string(body))
+	}
+
+	// the format can be guessed
+	// from the transcript
+	status, body, err = upload("transcript", "text/plain", string(TestVTT))
+	if err != nil || status != http.StatusOK || !strings.Contains(string(body), `"speaker":"Bob"`) {
+		t.Errorf("ERROR: the WebVTT transcript should be analyzed\n\t%v\n\t%v\n", err, string(body))
+	}
+}
+
+func TestTranscriptShouldFail1(t *testing.T) {
+	for format, data := range map[string]string{
+		FormatSRT:     "1\n00:00:01,000 -> 00:00:02,000\nhi\n",
+		FormatVTT:     "00:01.000 --> 00:02.000\nno header\n",
+		FormatWhisper: `{"text": "no segments"}`,
+		"docx":        "hi",
+	} {
+		if series, err := ParseTranscript(format, []byte(data)); err == nil {
+			t.Errorf("ERROR: the %v transcript should not be read\n\t%+v\n", format, series)
+		}
+	}
+	if _, err := parseTimestamp("00:00:75,000"); err == nil {
+		t.Errorf("ERROR: seconds past 59 should not be read\n")
+	}
+
+	hook := Hook{URL: "http://127.0.0.1:8080/test/formats/srt", Format: FormatSRT, Key: "text"}
+	if err := hook.Prepare(); err == nil {
+		t.Errorf("ERROR: transcript hooks should not give a key\n")
+	}
+
+	hook = Hook{URL: "http://127.0.0.1:8080/test/formats/csv", Format: FormatVTT}
+	err := hook.Prepare()
+	if err != nil {
+		t.Fatalf("ERROR: the hook should be valid\n\t%v\n", err)
+	}
+	if _, err = hook.Fetch("transcript-wrong", TaskJSON{ID: "1"}); err == nil {
+		t.Errorf("ERROR: the CSV response should not be read as a transcript\n")
+	}
+
+	status, body, err := upload("transcript?format=srt", "text/plain", string(TestVTT))
+	if err != nil || status != http.StatusBadRequest {
+		t.Errorf("ERROR: status returned should be 400 Bad Request\n\t%v\n\t%v\n", err, string(body))
+	}
+
+	status, body, err = upload("transcript", "text/plain", "")
+	if err != nil || status != http.StatusBadRequest {
+		t.Errorf("ERROR: status returned should be 400 Bad Request\n\t%v\n\t%v\n", err, string(body))
+	}
+}
+
 // * Benchmarks * //
 
 func BenchmarkPOSTAnalyze(b *testing.B) {
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signingAlgorithms are the hashes
+// hook requests can be signed with
+var signingAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// SigningConfig configures HMAC signing of a
+// hook's requests. Algorithm is one of sha1,
+// sha256 (the default) or sha512. Secret is the
+// HMAC key, which can reference ${env:NAME} and
+// ${file:/path} secrets.
+//
+// The signature covers the request's method,
+// path and query, the time it was signed (sent
+// in TimestampHeader as unix seconds,) the
+// Headers listed (in order,) and the SHA-256 of
+// the body, each on their own line:
+//
+//	GET
+//	/comments/123?page=1
+//	1456000000
+//	host:api.example.com
+//	authorization:Bearer abc
+//	e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+//
+// It's sent in the Header (which defaults to
+// X-Signature) as the algorithm and hex MAC,
+// like sha256=5d5d139563c95b59...
+type SigningConfig struct {
+	Algorithm       string   `json:"algorithm,omitempty"`
+	Secret          string   `json:"secret"`
+	Header          string   `json:"header,omitempty"`
+	Headers         []string `json:"headers,omitempty"`
+	TimestampHeader string   `json:"timestampHeader,omitempty"`
+	secret          string
+}
+
+// prepare validates the config, fills in its
+// defaults, and resolves the secret with the
+// hook's secrets
+func (c *SigningConfig) prepare(h *Hook) error {
+	c.Algorithm = strings.ToLower(c.Algorithm)
+	if c.Algorithm == "" {
+		c.Algorithm = "sha256"
+	}
+	if _, ok := signingAlgorithms[c.Algorithm]; !ok {
+		return fmt.Errorf("unknown algorithm '%v' (expected sha1, sha256 or sha512)", c.Algorithm)
+	}
+
+	if c.Secret == "" {
+		return fmt.Errorf("a secret must be given")
+	}
+	c.secret = h.interpolate(c.Secret)
+
+	if c.Header == "" {
+		c.Header = "X-Signature"
+	}
+	if c.TimestampHeader == "" {
+		c.TimestampHeader = "X-Timestamp"
+	}
+
+	return nil
+}
+
+// SigningString returns the string signed for
+// a request, as described by SigningConfig
+func (c *SigningConfig) SigningString(req *http.Request, body []byte) string {
+	var s strings.Builder
+	s.WriteString(req.Method + "\n")
+	s.WriteString(req.URL.RequestURI() + "\n")
+	s.WriteString(req.Header.Get(c.TimestampHeader) + "\n")
+
+	for _, name := range c.Headers {
+		value := req.Header.Get(name)
+		if strings.EqualFold(name, "host") {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		s.WriteString(strings.ToLower(name) + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	sum := sha256.Sum256(body)
+	s.WriteString(hex.EncodeToString(sum[:]))
+
+	return s.String()
+}
+
+// Sign timestamps and signs a request. It's
+// called for every attempt, after the request
+// is authorized, so retries get a fresh
+// timestamp and the signature can cover the
+// Authorization header.
+func (c *SigningConfig) Sign(req *http.Request) error {
+	var body []byte
+	if req.GetBody != nil {
+		r, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		body, err = ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	req.Header.Set(c.TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+
+	mac := hmac.New(signingAlgorithms[c.Algorithm], []byte(c.secret))
+	mac.Write([]byte(c.SigningString(req, body)))
+	req.Header.Set(c.Header, c.Algorithm+"="+hex.EncodeToString(mac.Sum(nil)))
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig configures TLS for a hook's
+// requests. CertFile and KeyFile give a PEM
+// client certificate and key for mutual TLS,
+// and CAFile a PEM bundle of the certificate
+// authorities trusted for the upstream
+// (instead of the system's.) ServerName
+// overrides the name the upstream's
+// certificate is verified against.
+type TLSConfig struct {
+	CertFile   string `json:"certFile,omitempty"`
+	KeyFile    string `json:"keyFile,omitempty"`
+	CAFile     string `json:"caFile,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// Load reads the certificates and builds
+// the tls.Config for the hook's client
+func (c *TLSConfig) Load() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("a client certificate needs both a certFile and a keyFile")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CAFile != "" {
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA bundle: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("CA bundle %v holds no PEM certificates", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
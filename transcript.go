@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cdipaolo/sentiment"
+)
+
+const (
+	// FormatSRT reads hook responses as
+	// SubRip (.srt) subtitles
+	FormatSRT = "srt"
+
+	// FormatVTT reads hook responses as
+	// WebVTT (.vtt) subtitles
+	FormatVTT = "vtt"
+
+	// FormatWhisper reads hook responses as
+	// Whisper-style JSON transcript segments
+	FormatWhisper = "whisper"
+)
+
+var (
+	// voiceTag matches a WebVTT voice span
+	// (<v Name> or <v.class Name>) at the
+	// start of a line of cue text
+	voiceTag = regexp.MustCompile(`^<v(?:\.[^\s>]*)?[ \t]+([^>]*)>`)
+
+	// speakerLabel matches an upper case
+	// speaker name followed by a colon at the
+	// start of a line of cue text, optionally
+	// after the ">>" captioners use to mark
+	// a new speaker
+	speakerLabel = regexp.MustCompile(`^(?:>>\s*)?([A-Z][A-Z0-9 .'\-]{0,38}[A-Z0-9.]|[A-Z]):\s*(.*)$`)
+
+	// subtitleOverride matches the {\an8}
+	// style overrides found in SRT cues
+	subtitleOverride = regexp.MustCompile(`\{\\[^}]*\}`)
+)
+
+// whisperSegment is a segment of a
+// Whisper-style JSON transcript
+type whisperSegment struct {
+	Start   *float64      `json:"start"`
+	End     *float64      `json:"end"`
+	Text    string        `json:"text"`
+	Speaker string        `json:"speaker"`
+	Words   []whisperWord `json:"words"`
+}
+
+// whisperWord is a word (with its own
+// timestamps) within a whisperSegment
+type whisperWord struct {
+	Word    string   `json:"word"`
+	Start   *float64 `json:"start"`
+	End     *float64 `json:"end"`
+	Speaker string   `json:"speaker"`
+}
+
+// IsTranscriptFormat returns whether the
+// format is one of the transcript formats
+// read by ParseTranscript
+func IsTranscriptFormat(format string) bool {
+	return format == FormatSRT || format == FormatVTT || format == FormatWhisper
+}
+
+// DetectTranscriptFormat guesses the format
+// of a transcript from its content type,
+// falling back to looking at the transcript
+// itself
+func DetectTranscriptFormat(data []byte, contentType string) string {
+	switch media := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])); {
+	case media == "text/vtt":
+		return FormatVTT
+	case strings.HasSuffix(media, "subrip") || media == "text/srt":
+		return FormatSRT
+	case strings.HasSuffix(media, "json"):
+		return FormatWhisper
+	}
+
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("WEBVTT")):
+		return FormatVTT
+	case bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")):
+		return FormatWhisper
+	}
+
+	return FormatSRT
+}
+
+// ParseTranscript reads a transcript in the
+// given format into time series entries, with
+// times in milliseconds. Speaker labels are
+// kept where the transcript gives them: WebVTT
+// voice spans, upper case "NAME:" prefixes in
+// SRT and WebVTT cues, and the speaker of
+// Whisper segments (or of their words, which
+// splits a segment wherever the speaker
+// changes.)
+func ParseTranscript(format string, data []byte) ([]TimeSeries, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	switch format {
+	case FormatSRT:
+		return parseSRT(data)
+	case FormatVTT:
+		return parseWebVTT(data)
+	case FormatWhisper:
+		return parseWhisper(data)
+	}
+
+	return nil, fmt.Errorf("unknown transcript format '%v' (expected 'srt', 'vtt' or 'whisper')", format)
+}
+
+// parseSRT reads SubRip subtitles, which
+// are blank line separated cues of a number,
+// a timing line and the cue text
+func parseSRT(data []byte) ([]TimeSeries, error) {
+	series := []TimeSeries{}
+	for i, block := range cueBlocks(data) {
+		cue, err := parseCue(block, false)
+		if err != nil {
+			return nil, fmt.Errorf("cue %v: %v", i+1, err)
+		}
+		series = append(series, cue...)
+	}
+
+	if len(series) == 0 {
+		return nil, fmt.Errorf("the transcript has no cues")
+	}
+	return series, nil
+}
+
+// parseWebVTT reads WebVTT subtitles, which
+// start with a WEBVTT header and hold cues
+// (with optional identifiers) along with
+// NOTE, STYLE and REGION blocks
+func parseWebVTT(data []byte) ([]TimeSeries, error) {
+	blocks := cueBlocks(data)
+	if len(blocks) == 0 || !strings.HasPrefix(blocks[0][0], "WEBVTT") {
+		return nil, fmt.Errorf("the transcript has no WEBVTT header")
+	}
+
+	series := []TimeSeries{}
+	for i, block := range blocks[1:] {
+		switch first := strings.Fields(block[0]); {
+		case len(first) == 0:
+		case first[0] == "NOTE" || first[0] == "STYLE" || first[0] == "REGION":
+			continue
+		}
+
+		cue, err := parseCue(block, true)
+		if err != nil {
+			return nil, fmt.Errorf("cue %v: %v", i+1, err)
+		}
+		series = append(series, cue...)
+	}
+
+	if len(series) == 0 {
+		return nil, fmt.Errorf("the transcript has no cues")
+	}
+	return series, nil
+}
+
+// cueBlocks splits subtitles into their
+// blank line separated blocks of lines
+func cueBlocks(data []byte) [][]string {
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+
+	blocks := [][]string{}
+	block := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(block) != 0 {
+				blocks = append(blocks, block)
+				block = []string{}
+			}
+			continue
+		}
+		block = append(block, line)
+	}
+	if len(block) != 0 {
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+// parseCue reads a subtitle cue: an optional
+// identifier, the timing line, and the text.
+// The text is split into an entry for each
+// speaker within it. Voice spans are only
+// read for WebVTT cues.
+func parseCue(lines []string, vtt bool) ([]TimeSeries, error) {
+	timing := 0
+	if !strings.Contains(lines[0], "-->") {
+		timing = 1
+	}
+	if timing >= len(lines) || !strings.Contains(lines[timing], "-->") {
+		return nil, fmt.Errorf("the cue has no timing line")
+	}
+
+	fields := strings.Fields(lines[timing])
+	if len(fields) < 3 || fields[1] != "-->" {
+		return nil, fmt.Errorf("invalid timing line '%v'", lines[timing])
+	}
+	start, err := parseTimestamp(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimestamp(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	series := []TimeSeries{}
+	speaker := ""
+	for _, line := range lines[timing+1:] {
+		line = strings.TrimSpace(line)
+		if vtt {
+			if m := voiceTag.FindStringSubmatch(line); m != nil {
+				speaker = strings.TrimSpace(m[1])
+				line = line[len(m[0]):]
+			}
+		}
+		line = subtitleOverride.ReplaceAllString(line, "")
+		line = html.UnescapeString(htmlTag.ReplaceAllString(line, ""))
+		if m := speakerLabel.FindStringSubmatch(line); m != nil {
+			speaker, line = strings.TrimSpace(m[1]), m[2]
+		}
+
+		text := strings.Join(strings.Fields(line), " ")
+		if text == "" {
+			continue
+		}
+
+		last := len(series) - 1
+		if last >= 0 && series[last].Speaker == speaker {
+			series[last].Text += " " + text
+			continue
+		}
+		series = append(series, TimeSeries{
+			Start:   start,
+			End:     end,
+			Speaker: speaker,
+			Text:    text,
+		})
+	}
+
+	return series, nil
+}
+
+// parseTimestamp reads a subtitle timestamp
+// (HH:MM:SS,mmm in SRT and HH:MM:SS.mmm or
+// MM:SS.mmm in WebVTT) in milliseconds
+func parseTimestamp(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp '%v'", s)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.Replace(parts[len(parts)-1], ",", ".", 1), 64)
+	if err != nil || seconds < 0 || seconds >= 60 {
+		return 0, fmt.Errorf("invalid timestamp '%v'", s)
+	}
+
+	for i, unit := range []float64{60, 3600}[:len(parts)-1] {
+		n, err := strconv.Atoi(parts[len(parts)-2-i])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid timestamp '%v'", s)
+		}
+		seconds += float64(n) * unit
+	}
+
+	return math.Round(seconds * 1000), nil
+}
+
+// parseWhisper reads a Whisper-style JSON
+// transcript: an object holding an array
+// of "segments" (or that array itself) with
+// times in seconds. Segments whose words are
+// given different speakers (as diarized
+// transcripts do) are split at each change
+// of speaker.
+func parseWhisper(data []byte) ([]TimeSeries, error) {
+	var segments []whisperSegment
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		err := json.Unmarshal(trimmed, &segments)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		body := struct {
+			Segments *[]whisperSegment `json:"segments"`
+		}{Segments: &segments}
+		err := json.Unmarshal(trimmed, &body)
+		if err != nil {
+			return nil, err
+		}
+		if body.Segments == nil || segments == nil {
+			return nil, fmt.Errorf("the transcript has no segments")
+		}
+	}
+
+	series := []TimeSeries{}
+	for i, segment := range segments {
+		if segment.Start == nil || segment.End == nil {
+			return nil, fmt.Errorf("segment %v has no start or end", i+1)
+		}
+
+		runs := segment.runs()
+		if runs == nil {
+			text := strings.Join(strings.Fields(segment.Text), " ")
+			if text == "" {
+				continue
+			}
+			speaker := segment.Speaker
+			if speaker == "" && len(segment.Words) != 0 {
+				speaker = segment.Words[0].Speaker
+			}
+			runs = []TimeSeries{{
+				Start:   *segment.Start * 1000,
+				End:     *segment.End * 1000,
+				Speaker: speaker,
+				Text:    text,
+			}}
+		}
+		series = append(series, runs...)
+	}
+
+	return series, nil
+}
+
+// runs splits the segment into an entry for
+// each run of words with the same speaker, or
+// returns nil if its words don't change the
+// speaker. Words without a speaker or times
+// take them from the words around them.
+func (s whisperSegment) runs() []TimeSeries {
+	split := false
+	for _, word := range s.Words {
+		if word.Speaker != "" && word.Speaker != s.Words[0].Speaker {
+			split = true
+			break
+		}
+	}
+	if !split {
+		return nil
+	}
+
+	runs := []TimeSeries{}
+	speaker := s.Speaker
+	for _, word := range s.Words {
+		if word.Speaker != "" {
+			speaker = word.Speaker
+		}
+		text := strings.TrimSpace(word.Word)
+		if text == "" {
+			continue
+		}
+
+		last := len(runs) - 1
+		if last < 0 || runs[last].Speaker != speaker {
+			start := *s.Start
+			if last >= 0 {
+				start = runs[last].End / 1000
+			}
+			if word.Start != nil {
+				start = *word.Start
+			}
+
+			runs = append(runs, TimeSeries{
+				Start:   start * 1000,
+				End:     start * 1000,
+				Speaker: speaker,
+			})
+			last++
+		} else {
+			runs[last].Text += " "
+		}
+
+		runs[last].Text += text
+		if word.End != nil {
+			runs[last].End = *word.End * 1000
+		}
+	}
+
+	if len(runs) != 0 {
+		runs[len(runs)-1].End = math.Max(runs[len(runs)-1].End, *s.End*1000)
+	}
+	return runs
+}
+
+// HandleTranscript takes a POST with a transcript
+// (SRT, WebVTT or Whisper-style JSON) as its body
+// and returns the analysis of the whole transcript
+// along with the score of each cue or segment, as
+// a TimeSeriesResponse. The "format" query param
+// gives the transcript's format, which is guessed
+// from the Content-Type and the transcript when
+// it's not given. The "lang" and "highlights"
+// query params work like those of POST /analyze.
+func HandleTranscript(r http.ResponseWriter, req *http.Request) {
+	r.Header().Add("Content-Type", "application/json")
+
+	if req.ContentLength < 1 {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "no transcript passed. Cannot run sentiment analysis"}`)))
+		log.Printf("POST /transcript > ERROR: no transcript passed\n")
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil && err != io.EOF {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: error reading request body", "error": "%v"}`, err.Error())))
+		log.Printf("POST /transcript > ERROR: couldn't read request body\n\t%v\n", err)
+		return
+	}
+
+	query := req.URL.Query()
+	highlights := 0
+	if h := query.Get("highlights"); h != "" {
+		highlights, err = strconv.Atoi(h)
+		if err != nil || highlights < 0 {
+			r.WriteHeader(http.StatusBadRequest)
+			r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: highlights must be a number of sentences", "highlights": "%v"}`, h)))
+			log.Printf("POST /transcript > ERROR: invalid highlights '%v'\n", h)
+			return
+		}
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = DetectTranscriptFormat(data, req.Header.Get("Content-Type"))
+	}
+
+	series, err := ParseTranscript(format, data)
+	if err != nil {
+		r.WriteHeader(http.StatusBadRequest)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: could not parse the transcript", "format": "%v", "error": "%v"}`, format, err.Error())))
+		log.Printf("POST /transcript > ERROR: could not parse the %v transcript\n\t%v\n", format, err)
+		return
+	}
+
+	lang := sentiment.Language(query.Get("lang"))
+	text := TurnTimeSeriesIntoText(series)
+
+	analysis := Analyze(text, lang, Config.Lexicon)
+	AddHighlights(analysis, text, Config.Lexicon, highlights)
+	for i := range series {
+		series[i].Score = Analyze(series[i].Text, lang, Config.Lexicon).Score
+	}
+
+	resp, err := json.Marshal(TimeSeriesResponse{
+		Metadata: analysis,
+		Series:   series,
+	})
+	if err != nil {
+		r.WriteHeader(http.StatusInternalServerError)
+		r.Write([]byte(fmt.Sprintf(`{"message": "ERROR: unable to marshal sentiment analysis into JSON", "error": "%v"}`, err.Error())))
+		log.Printf("POST /transcript > ERROR: unable to marshal sentiment analysis into JSON\n\t%v\n", err)
+		return
+	}
+
+	r.WriteHeader(http.StatusOK)
+	r.Write(resp)
+
+	atomic.AddInt64(&count, 1)
+	log.Printf("POST /transcript [format = %v, len(series) = %v]\n", format, len(series))
+}
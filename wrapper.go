@@ -47,3 +47,21 @@ func Delete(h http.HandlerFunc) http.HandlerFunc {
 func Put(h http.HandlerFunc) http.HandlerFunc {
 	return HTTPHandlerWithMethod("PUT", h)
 }
+
+// Methods routes requests to the handler for
+// their method, else returning an
+// http.StatusMethodNotAllowed status code as
+// well as an error
+func Methods(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(r http.ResponseWriter, req *http.Request) {
+		h, ok := handlers[req.Method]
+		if !ok {
+			r.Header().Add("Content-Type", "application/json")
+			r.WriteHeader(http.StatusMethodNotAllowed)
+			r.Write([]byte(fmt.Sprintf(`{"message": "Given method not allowed", "method": "%v"}`, req.Method)))
+			return
+		}
+
+		h(r, req)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// xmlText is the field holding the
+	// text of an XML element
+	xmlText = "#text"
+
+	// xmlAttr prefixes the fields holding
+	// the attributes of an XML element
+	xmlAttr = "@"
+)
+
+// xmlElement is an XML element being
+// read by parseXML
+type xmlElement struct {
+	fields   map[string]interface{}
+	text     bytes.Buffer
+	children bool
+}
+
+// value returns the decoded element. Elements
+// with only text are given as their text, and
+// others as an object holding their attributes
+// ("@name",) an array of the child elements of
+// each name, and their text ("#text".)
+func (e *xmlElement) value() interface{} {
+	text := strings.TrimSpace(e.text.String())
+	if len(e.fields) == 0 {
+		return text
+	}
+
+	e.fields[xmlText] = text
+	return e.fields
+}
+
+// parseXML decodes an XML document into the
+// same kind of values as encoding/json, so it
+// can be searched with a JSONPath (or an XPath
+// compiled by CompileXPath.) The document is
+// an object holding the root element. Names
+// are given without their namespaces.
+//
+// The text of an element includes the text of
+// its descendants, like the XPath string value.
+func parseXML(data []byte) (interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Entity = xml.HTMLEntity
+
+	root := &xmlElement{fields: map[string]interface{}{}}
+	stack := []*xmlElement{root}
+	names := []string{""}
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		current := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &xmlElement{fields: map[string]interface{}{}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					continue
+				}
+				e.fields[xmlAttr+attr.Name.Local] = attr.Value
+			}
+			current.children = true
+
+			stack = append(stack, e)
+			names = append(names, t.Name.Local)
+
+		case xml.EndElement:
+			parent := stack[len(stack)-2]
+			parent.text.Write(current.text.Bytes())
+
+			name := names[len(names)-1]
+			siblings, _ := parent.fields[name].([]interface{})
+			parent.fields[name] = append(siblings, current.value())
+
+			stack = stack[:len(stack)-1]
+			names = names[:len(names)-1]
+
+		case xml.CharData:
+			current.text.Write(t)
+		}
+	}
+
+	if !root.children {
+		return nil, fmt.Errorf("the document has no root element")
+	}
+
+	delete(root.fields, xmlText)
+	return root.fields, nil
+}
+
+// CompileXPath compiles an XPath-like selector
+// into a path over a document decoded by
+// parseXML. It supports:
+//
+//	/name        a child element (from the root
+//	             when at the start)
+//	//name       an element at any depth
+//	*            every child element
+//	name[2]      the second child element of
+//	             that name (counting from 1)
+//	@name        an attribute
+//	text()       the text of the element
+//	.            the current element
+//
+// Selectors not starting with / are relative
+// to the element they're used on, like the
+// fields of collection items. Selectors
+// starting with $ are compiled as JSONPaths.
+func CompileXPath(expr string) (*JSONPath, error) {
+	if strings.HasPrefix(expr, "$") {
+		return CompileJSONPath(expr)
+	}
+
+	if expr == "" {
+		return nil, fmt.Errorf("the selector is empty")
+	}
+
+	p := &JSONPath{expr: expr}
+	rest := expr
+	for rest != "" {
+		descend := false
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			descend = true
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "/"):
+			rest = rest[1:]
+		case len(p.steps) != 0:
+			return nil, fmt.Errorf("expected '/' before '%v' in '%v'", rest, expr)
+		}
+
+		step := rest
+		if end := strings.IndexByte(rest, '/'); end >= 0 {
+			step, rest = rest[:end], rest[end:]
+		} else {
+			rest = ""
+		}
+
+		name, position := step, 0
+		if open := strings.IndexByte(step, '['); open >= 0 {
+			if !strings.HasSuffix(step, "]") {
+				return nil, fmt.Errorf("unclosed '[' in '%v'", expr)
+			}
+
+			var err error
+			name = step[:open]
+			position, err = strconv.Atoi(step[open+1 : len(step)-1])
+			if err != nil || position < 1 {
+				return nil, fmt.Errorf("positions must be numbers from 1 in '%v'", expr)
+			}
+		}
+
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("expected a name in '%v'", expr)
+		case name == ".":
+			if descend || position != 0 {
+				return nil, fmt.Errorf("'.' can't be used there in '%v'", expr)
+			}
+			continue
+		case name == "text()" || strings.HasPrefix(name, xmlAttr):
+			if descend || position != 0 || rest != "" {
+				return nil, fmt.Errorf("'%v' must be the last step in '%v'", name, expr)
+			}
+			if name == "text()" {
+				p.steps = append(p.steps, pathStep{kind: stepText})
+			} else {
+				p.steps = append(p.steps, pathStep{kind: stepField, name: name})
+			}
+			continue
+		}
+
+		switch {
+		case name == "*" && descend:
+			return nil, fmt.Errorf("'//*' is not supported in '%v'", expr)
+		case name == "*":
+			p.steps = append(p.steps, pathStep{kind: stepWildcard})
+		case descend:
+			p.steps = append(p.steps, pathStep{kind: stepDescend, name: name})
+		default:
+			p.steps = append(p.steps, pathStep{kind: stepField, name: name})
+		}
+
+		if position != 0 {
+			p.steps = append(p.steps, pathStep{kind: stepIndex, index: position - 1})
+		} else {
+			p.steps = append(p.steps, pathStep{kind: stepWildcard})
+		}
+	}
+
+	return p, nil
+}